@@ -37,6 +37,11 @@ type Accounts struct {
 	insertAccount  *sql.Stmt
 	selectAccounts *sql.Stmt
 	selectTxns     *sql.Stmt
+
+	// journal of batch(Ledger.BatchStart), original values of changed accounts
+	batch      bool
+	batch_num  int
+	batch_orig map[int]Account
 }
 
 func NewAccounts(db *sql.DB) (*Accounts, error) {
@@ -44,7 +49,7 @@ func NewAccounts(db *sql.DB) (*Accounts, error) {
 
 	self.pubKeyIndex = make(map[[48]byte]int)
 
-	_, err := db.Exec("CREATE TABLE Accounts(pub_key BLOB);")
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS Accounts(pub_key BLOB);")
 	if err != nil {
 		self.Destroy()
 		return nil, fmt.Errorf("NewAccounts() Exec() failed: %w", err)
@@ -62,7 +67,7 @@ func NewAccounts(db *sql.DB) (*Accounts, error) {
 		return nil, fmt.Errorf("NewAccounts() selectAccounts stmt failed: %w", err)
 	}
 
-	self.selectTxns, err = db.Prepare("SELECT amount, nonce, MAX(_rowid_) FROM Txns GROUP BY account_id;")
+	self.selectTxns, err = db.Prepare("SELECT account_id, amount, nonce, MAX(_rowid_) FROM Txns GROUP BY account_id;")
 	if err != nil {
 		self.Destroy()
 		return nil, fmt.Errorf("NewAccounts() selectTxns stmt failed: %w", err)
@@ -104,21 +109,32 @@ func NewAccounts(db *sql.DB) (*Accounts, error) {
 	{
 		rows, err := self.selectTxns.Query()
 		if err != nil {
+			self.Destroy()
 			return nil, fmt.Errorf("NewAccounts() selectTxns.Query() failed: %w", err)
 		}
 		defer rows.Close()
 
-		i := 0
 		for rows.Next() {
-			if i >= len(self.accounts) {
-				return nil, fmt.Errorf("NewAccounts() selectTxns out of accounts len: %w", err)
-			}
-
-			acc := self.accounts[i]
-			err := rows.Scan(&acc.amount, &acc.nonce, &acc.txn_row)
+			var account_id, amount, nonce, txn_row int64
+			err := rows.Scan(&account_id, &amount, &nonce, &txn_row)
 			if err != nil {
+				self.Destroy()
 				return nil, fmt.Errorf("NewAccounts() selectTxns.Scan() failed: %w", err)
 			}
+			if account_id < 0 || account_id >= int64(len(self.accounts)) {
+				self.Destroy()
+				return nil, fmt.Errorf("NewAccounts() selectTxns account(%d) is out of accounts len(%d)", account_id, len(self.accounts))
+			}
+
+			acc := self.accounts[account_id]
+			acc.amount = amount
+			acc.nonce = nonce
+			acc.txn_row = txn_row
+		}
+		err = rows.Err()
+		if err != nil {
+			self.Destroy()
+			return nil, fmt.Errorf("NewAccounts() selectTxns rows failed: %w", err)
 		}
 	}
 
@@ -193,10 +209,42 @@ func (accs *Accounts) Add(pubKey *BLSPubKey) (int, error) {
 	return i, nil
 }
 
+// starts journal, changes can be reverted by Rollback()
+func (accs *Accounts) Begin() {
+	accs.batch = true
+	accs.batch_num = len(accs.accounts)
+	accs.batch_orig = make(map[int]Account)
+}
+
+// must be called before account is changed
+func (accs *Accounts) Backup(i int) {
+	if !accs.batch || i >= accs.batch_num {
+		return // new accounts are removed by Rollback()
+	}
+	_, found := accs.batch_orig[i]
+	if !found {
+		accs.batch_orig[i] = *accs.accounts[i]
+	}
+}
+
+func (accs *Accounts) Commit() {
+	accs.batch = false
+	accs.batch_orig = nil
+}
+
+// returns accounts into state from Begin()
 func (accs *Accounts) Rollback() {
-	// resize down accounts ...
-	// re-read amount,nonce,txn_row from Txns table ...
-	// remove indexes bigger than max_accounts ...
+	if !accs.batch {
+		return
+	}
+	for i, acc := range accs.batch_orig {
+		*accs.accounts[i] = acc
+	}
+	for _, acc := range accs.accounts[accs.batch_num:] {
+		delete(accs.pubKeyIndex, acc.pubKey.arr)
+	}
+	accs.accounts = accs.accounts[:accs.batch_num]
+	accs.Commit()
 }
 
 func (accs *Accounts) SumAmounts() int64 {
@@ -0,0 +1,268 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const AddrBook_MAX = 10000
+const AddrBook_RETRY_MIN = 60         // sec
+const AddrBook_RETRY_MAX = 3600       // sec
+const AddrBook_FORGET = 7 * 24 * 3600 // sec, address which was never seen for this long is removed
+
+const (
+	AddrBook_SOURCE_SEED    = "seed"
+	AddrBook_SOURCE_PEER    = "peer"
+	AddrBook_SOURCE_INBOUND = "inbound"
+)
+
+type AddrBookItem struct {
+	Addr        string `json:"addr"` // host:port
+	Source      string `json:"source"`
+	Added       int64  `json:"added"` // unix time
+	Last_seen   int64  `json:"last_seen"`
+	Last_failed int64  `json:"last_failed"`
+	Fails       int    `json:"fails"`
+}
+
+// Known peer addresses, stored in json file
+type AddrBook struct {
+	lock sync.Mutex
+
+	path  string
+	items map[string]*AddrBookItem
+}
+
+func NewAddrBook(path string, seeds []string) (*AddrBook, error) {
+	var book AddrBook
+	book.path = path
+	book.items = make(map[string]*AddrBookItem)
+
+	if OsFileExists(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("NewAddrBook() ReadFile() failed: %w", err)
+		}
+
+		var items []*AddrBookItem
+		err = json.Unmarshal(data, &items)
+		if err != nil {
+			return nil, fmt.Errorf("NewAddrBook() Unmarshal() failed: %w", err)
+		}
+		for _, it := range items {
+			if AddrBook_IsValid(it.Addr) {
+				book.items[it.Addr] = it
+			}
+		}
+	}
+
+	for _, addr := range seeds {
+		book.Add(addr, AddrBook_SOURCE_SEED)
+	}
+
+	return &book, nil
+}
+
+func (book *AddrBook) Save() error {
+
+	book.lock.Lock()
+	items := make([]*AddrBookItem, 0, len(book.items))
+	for _, it := range book.items {
+		items = append(items, it)
+	}
+	book.lock.Unlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Addr < items[j].Addr })
+
+	data, err := json.MarshalIndent(items, "", "\t")
+	if err != nil {
+		return fmt.Errorf("AddrBook.Save() Marshal() failed: %w", err)
+	}
+
+	err = os.WriteFile(book.path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("AddrBook.Save() WriteFile() failed: %w", err)
+	}
+	return nil
+}
+
+// addr must be "host:port"
+func AddrBook_IsValid(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || len(host) == 0 || len(addr) > 256 {
+		return false
+	}
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p < 65536
+}
+
+// group of network(IPv4 /16, IPv6 /32 or hostname). Outbound peers are picked from different groups first
+func AddrBook_Group(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d", ip4[0], ip4[1])
+	}
+	return fmt.Sprintf("%x", []byte(ip[:4]))
+}
+
+// returns false if address is invalid or already known
+func (book *AddrBook) Add(addr string, source string) bool {
+
+	if !AddrBook_IsValid(addr) {
+		return false
+	}
+
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	_, found := book.items[addr]
+	if found || len(book.items) >= AddrBook_MAX {
+		return false
+	}
+
+	book.items[addr] = &AddrBookItem{Addr: addr, Source: source, Added: time.Now().Unix()}
+	return true
+}
+
+func (book *AddrBook) Remove(addr string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	delete(book.items, addr)
+}
+
+func (book *AddrBook) MarkSeen(addr string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	it, found := book.items[addr]
+	if found {
+		it.Last_seen = time.Now().Unix()
+		it.Fails = 0
+	}
+}
+
+func (book *AddrBook) MarkFailed(addr string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	it, found := book.items[addr]
+	if !found {
+		return
+	}
+	it.Last_failed = time.Now().Unix()
+	it.Fails++
+
+	// forget address which never worked
+	last := it.Last_seen
+	if last == 0 {
+		last = it.Added
+	}
+	if it.Source != AddrBook_SOURCE_SEED && it.Last_failed-last > AddrBook_FORGET {
+		delete(book.items, addr)
+	}
+}
+
+func (it *AddrBookItem) _canRetry(now int64) bool {
+	if it.Fails == 0 {
+		return true
+	}
+	wait := int64(AddrBook_RETRY_MIN) << OsMin(it.Fails-1, 16)
+	if wait > AddrBook_RETRY_MAX {
+		wait = AddrBook_RETRY_MAX
+	}
+	return now-it.Last_failed >= wait
+}
+
+// returns most recently seen addresses, which are shared with peers(MSG_ADDR)
+func (book *AddrBook) List(max int) []string {
+
+	book.lock.Lock()
+	var items []*AddrBookItem
+	for _, it := range book.items {
+		if it.Last_seen > 0 {
+			items = append(items, it)
+		}
+	}
+	book.lock.Unlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Last_seen > items[j].Last_seen })
+
+	var ret []string
+	for i := 0; i < len(items) && i < max; i++ {
+		ret = append(ret, items[i].Addr)
+	}
+	return ret
+}
+
+// picks addresses for new outbound connections. One per network group first, then the rest
+func (book *AddrBook) Pick(num int, exclude map[string]bool) []string {
+
+	now := time.Now().Unix()
+
+	book.lock.Lock()
+	var items []*AddrBookItem
+	for _, it := range book.items {
+		if !exclude[it.Addr] && it._canRetry(now) {
+			items = append(items, it)
+		}
+	}
+	book.lock.Unlock()
+
+	rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+
+	// already connected groups
+	groups := make(map[string]bool)
+	for addr := range exclude {
+		groups[AddrBook_Group(addr)] = true
+	}
+
+	var ret []string
+	var rest []string
+	for _, it := range items {
+		if len(ret) >= num {
+			break
+		}
+		g := AddrBook_Group(it.Addr)
+		if groups[g] {
+			rest = append(rest, it.Addr)
+			continue
+		}
+		groups[g] = true
+		ret = append(ret, it.Addr)
+	}
+	for i := 0; i < len(rest) && len(ret) < num; i++ {
+		ret = append(ret, rest[i])
+	}
+	return ret
+}
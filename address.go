@@ -0,0 +1,210 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Text form of account. Bech32m(BIP-350) with network prefix:
+// pubKey: <prefix>1<48 bytes pubKey><checksum>, e.g. tin1...
+// registered account: <prefix>id1<id><checksum>, e.g. tinid1...
+const Address_CHARSET = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+const Address_BECH32M = 0x2bc830a3
+const Address_MAX_LEN = 90
+const Address_ID_SUFFIX = "id"
+
+type Address struct {
+	pubKey *BLSPubKey // nil for short id
+	id     int64
+}
+
+func _Address_polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func _Address_hrpExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+}
+
+func _Address_convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var ret []byte
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<to - 1
+	for _, v := range data {
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			ret = append(ret, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return ret, nil
+}
+
+func _Address_encode(hrp string, data []byte) string {
+	values, _ := _Address_convertBits(data, 8, 5, true)
+
+	chk := _Address_polymod(append(append(_Address_hrpExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ Address_BECH32M
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(chk>>(5*(5-i))&31))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(Address_CHARSET[v])
+	}
+	return sb.String()
+}
+
+// returns hrp and 5-bit values without checksum
+func _Address_decode(str string) (string, []byte, error) {
+	if len(str) > Address_MAX_LEN {
+		return "", nil, errors.New("too long")
+	}
+	for i := 0; i < len(str); i++ {
+		if str[i] < 33 || str[i] > 126 {
+			return "", nil, fmt.Errorf("invalid character(%d)", str[i])
+		}
+	}
+	if strings.ToLower(str) != str && strings.ToUpper(str) != str {
+		return "", nil, errors.New("mixed case")
+	}
+	str = strings.ToLower(str)
+
+	pos := strings.LastIndexByte(str, '1')
+	if pos < 1 || pos+7 > len(str) {
+		return "", nil, errors.New("missing separator or checksum")
+	}
+	hrp := str[:pos]
+
+	var values []byte
+	for i := pos + 1; i < len(str); i++ {
+		v := strings.IndexByte(Address_CHARSET, str[i])
+		if v < 0 {
+			return "", nil, fmt.Errorf("invalid character '%c'", str[i])
+		}
+		values = append(values, byte(v))
+	}
+	if _Address_polymod(append(_Address_hrpExpand(hrp), values...)) != Address_BECH32M {
+		return "", nil, errors.New("invalid checksum")
+	}
+	return hrp, values[:len(values)-6], nil
+}
+
+func NewAddressPubKey(pubKey *BLSPubKey) *Address {
+	return &Address{pubKey: pubKey, id: -1}
+}
+
+func NewAddressId(id int64) *Address {
+	return &Address{id: id}
+}
+
+// validates checksum, network prefix and pubKey(G1 point)
+func Address_Parse(str string) (*Address, error) {
+	hrp, values, err := _Address_decode(strings.TrimSpace(str))
+	if err != nil {
+		return nil, fmt.Errorf("Address_Parse() invalid address '%s': %w", str, err)
+	}
+	data, err := _Address_convertBits(values, 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("Address_Parse() invalid address '%s': %w", str, err)
+	}
+
+	prefix := NetParams_Active.AddrPrefix
+	switch hrp {
+	case prefix:
+		if len(data) != len(BLSPubKey{}.arr) {
+			return nil, fmt.Errorf("Address_Parse() address '%s' has invalid size(%d)", str, len(data))
+		}
+		pubKey := &BLSPubKey{arr: [48]byte(data)}
+		err = pubKey.Check()
+		if err != nil {
+			return nil, fmt.Errorf("Address_Parse() address '%s' has invalid pubKey: %w", str, err)
+		}
+		return NewAddressPubKey(pubKey), nil
+
+	case prefix + Address_ID_SUFFIX:
+		if len(data) == 0 || len(data) > 8 || (len(data) > 1 && data[0] == 0) {
+			return nil, fmt.Errorf("Address_Parse() address '%s' has invalid id", str)
+		}
+		var arr [8]byte
+		copy(arr[8-len(data):], data)
+		id := int64(binary.BigEndian.Uint64(arr[:]))
+		if id < 0 {
+			return nil, fmt.Errorf("Address_Parse() address '%s' has invalid id", str)
+		}
+		return NewAddressId(id), nil
+	}
+
+	for _, fn := range NetParams_SETS {
+		p := fn()
+		if hrp == p.AddrPrefix || hrp == p.AddrPrefix+Address_ID_SUFFIX {
+			return nil, fmt.Errorf("Address_Parse() address '%s' is for %s, not %s", str, p.Name, NetParams_Active.Name)
+		}
+	}
+	return nil, fmt.Errorf("Address_Parse() address '%s' has unknown prefix '%s', expected '%s'", str, hrp, prefix)
+}
+
+func (addr *Address) IsShort() bool {
+	return addr.pubKey == nil
+}
+
+func (addr *Address) String() string {
+	if addr.IsShort() {
+		var arr [8]byte
+		binary.BigEndian.PutUint64(arr[:], uint64(addr.id))
+		i := 0
+		for i < 7 && arr[i] == 0 {
+			i++
+		}
+		return _Address_encode(NetParams_Active.AddrPrefix+Address_ID_SUFFIX, arr[i:])
+	}
+	return _Address_encode(NetParams_Active.AddrPrefix, addr.pubKey.arr[:])
+}
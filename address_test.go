@@ -0,0 +1,142 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// test vectors from BIP-350
+func TestAddressBech32mValid(t *testing.T) {
+	for _, str := range []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	} {
+		hrp, _, err := _Address_decode(str)
+		if err != nil {
+			t.Errorf("%s: %v", str, err)
+			continue
+		}
+		if hrp != strings.ToLower(str[:strings.LastIndexByte(str, '1')]) {
+			t.Errorf("%s: wrong hrp %s", str, hrp)
+		}
+	}
+}
+
+func TestAddressBech32mInvalid(t *testing.T) {
+	for _, str := range []string{
+		"\x201xj0phk", // hrp character out of range
+		"\x7f1g6xzxy", // hrp character out of range
+		"\x801vctc34", // hrp character out of range
+		"an84characterslonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11d6pts4", // too long
+		"qyrz8wqd2c9m",  // no separator
+		"1qyrz8wqd2c9m", // empty hrp
+		"y1b0jsk6g",     // invalid data character
+		"lt1igcx5c0",    // invalid data character
+		"in1muywd",      // too short checksum
+		"mm1crxm3i",     // invalid character in checksum
+		"au1s5cgom",     // invalid character in checksum
+		"M1VUXWEZ",      // checksum calculated with uppercase hrp
+		"16plkw9",       // empty hrp
+		"1p2gdwpf",      // empty hrp
+		"a12uel5l",      // bech32(BIP-173), not bech32m
+		"A1lqfn3a",      // mixed case
+	} {
+		_, _, err := _Address_decode(str)
+		if err == nil {
+			t.Errorf("%q was accepted", str)
+		}
+	}
+}
+
+func TestAddressId(t *testing.T) {
+	for _, id := range []int64{0, 1, 255, 256, 1 << 40, 1<<63 - 1} {
+		str := NewAddressId(id).String()
+		if !strings.HasPrefix(str, NetParams_Active.AddrPrefix+Address_ID_SUFFIX+"1") {
+			t.Errorf("%d: wrong prefix %s", id, str)
+		}
+		addr, err := Address_Parse(str)
+		if err != nil {
+			t.Fatalf("%d: %v", id, err)
+		}
+		if !addr.IsShort() || addr.id != id {
+			t.Errorf("%d: parsed as %d", id, addr.id)
+		}
+	}
+
+	// id is big-endian without leading zeros
+	_, err := Address_Parse(_Address_encode(NetParams_Active.AddrPrefix+Address_ID_SUFFIX, []byte{0, 1}))
+	if err == nil {
+		t.Error("id with leading zero was accepted")
+	}
+	_, err = Address_Parse(_Address_encode(NetParams_Active.AddrPrefix+Address_ID_SUFFIX, []byte{0x80, 0, 0, 0, 0, 0, 0, 0}))
+	if err == nil {
+		t.Error("negative id was accepted")
+	}
+}
+
+func TestAddressNetwork(t *testing.T) {
+	str := NewAddressId(7).String() // mainnet
+
+	_Test_useParams(t, NetParams_Regtest())
+	_, err := Address_Parse(str)
+	if err == nil || !strings.Contains(err.Error(), "mainnet") {
+		t.Errorf("mainnet address on regtest returned %v", err)
+	}
+	_, err = Address_Parse(_Address_encode("xyz", []byte{7}))
+	if err == nil {
+		t.Error("unknown prefix was accepted")
+	}
+}
+
+func TestAddressPubKey(t *testing.T) {
+	err := InitBLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pubKey BLSPubKey
+	_Test_privKey(t, "0d7359d57963ab8fbbde1852dcf553fedbc31f464d80ee7d40ae683122b45070").ExportPublicKey(&pubKey)
+	addr, err := Address_Parse(NewAddressPubKey(&pubKey).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.IsShort() || !addr.pubKey.Cmp(&pubKey) {
+		t.Error("wrong pubKey")
+	}
+
+	// valid checksum, but not G1 point
+	var bad BLSPubKey
+	bad.arr[0] = 0x80 // compressed flag
+	bad.arr[47] = 1
+	_, err = Address_Parse(_Address_encode(NetParams_Active.AddrPrefix, bad.arr[:]))
+	if err == nil {
+		t.Error("invalid G1 point was accepted")
+	}
+	var infinity BLSPubKey
+	infinity.arr[0] = 0xc0 // compressed infinity
+	_, err = Address_Parse(_Address_encode(NetParams_Active.AddrPrefix, infinity.arr[:]))
+	if err == nil {
+		t.Error("G1 infinity was accepted")
+	}
+}
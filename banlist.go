@@ -0,0 +1,230 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const BanList_THRESHOLD = 100
+const BanList_DURATION = 24 * 3600 // sec
+const BanList_DECAY = 60           // sec, score decreases by 1 point
+const BanList_SCORES_MAX = 10000   // misbehaving peers which are remembered
+
+// Misbehavior scores
+const (
+	BAN_SCORE_INVALID_TXN   = 10
+	BAN_SCORE_INVALID_BLOCK = 50
+	BAN_SCORE_GARBAGE       = 20 // oversized or unreadable frame
+	BAN_SCORE_PROTOCOL      = 20 // unknown or unexpected message
+	BAN_SCORE_SYNC_TIMEOUT  = 10 // claimed height, but didn't send hashes
+)
+
+type BanListItem struct {
+	Key    string `json:"key"`   // node:<hex> of authenticated node or IP
+	Until  int64  `json:"until"` // unix time
+	Reason string `json:"reason"`
+}
+
+type BanListScore struct {
+	score int
+	time  int64 // unix time of last decay
+}
+
+// decreases score by time passed
+func (sc *BanListScore) Decay(now int64) {
+	steps := (now - sc.time) / BanList_DECAY
+	sc.score = OsMax(0, sc.score-int(OsMin64(steps, BanList_THRESHOLD)))
+	sc.time += steps * BanList_DECAY
+}
+
+// Misbehavior scores of peers and temporary bans, which are stored in json file
+type BanList struct {
+	lock sync.Mutex
+
+	path   string
+	scores map[string]*BanListScore
+	bans   map[string]*BanListItem
+}
+
+func NewBanList(path string) (*BanList, error) {
+	var self BanList
+	self.path = path
+	self.scores = make(map[string]*BanListScore)
+	self.bans = make(map[string]*BanListItem)
+
+	if OsFileExists(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("NewBanList() ReadFile() failed: %w", err)
+		}
+
+		var items []*BanListItem
+		err = json.Unmarshal(data, &items)
+		if err != nil {
+			return nil, fmt.Errorf("NewBanList() Unmarshal() failed: %w", err)
+		}
+		for _, it := range items {
+			self.bans[it.Key] = it
+		}
+	}
+
+	return &self, nil
+}
+
+func (bans *BanList) Save() error {
+
+	items := bans.List()
+
+	data, err := json.MarshalIndent(items, "", "\t")
+	if err != nil {
+		return fmt.Errorf("BanList.Save() Marshal() failed: %w", err)
+	}
+
+	err = os.WriteFile(bans.path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("BanList.Save() WriteFile() failed: %w", err)
+	}
+	return nil
+}
+
+// returns host part of "host:port"
+func BanList_Key(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+func (bans *BanList) IsBanned(key string) bool {
+
+	bans.lock.Lock()
+	defer bans.lock.Unlock()
+
+	it, found := bans.bans[key]
+	if !found {
+		return false
+	}
+	if it.Until <= time.Now().Unix() {
+		delete(bans.bans, key)
+		return false
+	}
+	return true
+}
+
+// adds score, returns true if peer is banned
+func (bans *BanList) Misbehave(key string, score int, reason string) bool {
+
+	now := time.Now().Unix()
+
+	bans.lock.Lock()
+	sc, found := bans.scores[key]
+	if found {
+		sc.Decay(now)
+	} else {
+		bans._freeScore(now)
+		sc = &BanListScore{time: now}
+		bans.scores[key] = sc
+	}
+	sc.score += score
+	total := sc.score
+	bans.lock.Unlock()
+
+	fmt.Printf("Peer %s misbehaved(+%d = %d): %s\n", key, score, total, reason)
+
+	if total < BanList_THRESHOLD {
+		return false
+	}
+
+	err := bans.Ban(key, BanList_DURATION, reason)
+	if err != nil {
+		fmt.Printf("Misbehave() failed: %v\n", err)
+	}
+	return true
+}
+
+// keeps scores map under BanList_SCORES_MAX: removes decayed scores, then the lowest one
+func (bans *BanList) _freeScore(now int64) {
+	if len(bans.scores) < BanList_SCORES_MAX {
+		return
+	}
+
+	lowest := ""
+	for key, sc := range bans.scores {
+		sc.Decay(now)
+		if sc.score == 0 {
+			delete(bans.scores, key)
+		} else if len(lowest) == 0 || sc.score < bans.scores[lowest].score {
+			lowest = key
+		}
+	}
+	if len(bans.scores) >= BanList_SCORES_MAX {
+		delete(bans.scores, lowest)
+	}
+}
+
+func (bans *BanList) Ban(key string, seconds int64, reason string) error {
+
+	bans.lock.Lock()
+	bans.bans[key] = &BanListItem{Key: key, Until: time.Now().Unix() + seconds, Reason: reason}
+	delete(bans.scores, key)
+	bans.lock.Unlock()
+
+	fmt.Printf("Peer %s banned for %dsec: %s\n", key, seconds, reason)
+
+	return bans.Save()
+}
+
+// empty key clears all bans
+func (bans *BanList) Clear(key string) error {
+
+	bans.lock.Lock()
+	if len(key) == 0 {
+		bans.bans = make(map[string]*BanListItem)
+		bans.scores = make(map[string]*BanListScore)
+	} else {
+		delete(bans.bans, key)
+		delete(bans.scores, key)
+	}
+	bans.lock.Unlock()
+
+	return bans.Save()
+}
+
+func (bans *BanList) List() []*BanListItem {
+
+	now := time.Now().Unix()
+
+	bans.lock.Lock()
+	var items []*BanListItem
+	for _, it := range bans.bans {
+		if it.Until > now {
+			items = append(items, it)
+		}
+	}
+	bans.lock.Unlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items
+}
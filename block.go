@@ -45,7 +45,7 @@ func (block *BlockRaw) ResetAndPrepare(buff *TBuffer) {
 	buff.Clear()
 
 	// Block always starts with Signiture
-	var aggSigns [BlockVerMT_NUM_AGG_SIGNITURES]BLSSign
+	aggSigns := make([]BLSSign, BlockVerMT_NUM_AGG_SIGNITURES)
 
 	for i := 0; i < len(aggSigns); i++ {
 		buff.WriteSBlob(aggSigns[i].arr[:])
@@ -58,7 +58,7 @@ func (block *BlockRaw) _Add(pubKey *bls.PublicKey, msg []byte, sign *bls.Sign) e
 		block.pubKeys = append(block.pubKeys, *pubKey)
 	}
 	if msg != nil {
-		h, err := TBuffer_sha256(msg)
+		h, err := TxnRaw_Hash(msg)
 		if err != nil {
 			return fmt.Errorf("BlockRaw._Add() sha256 failed: %w", err)
 		}
@@ -76,15 +76,18 @@ func _BlockRaw_AddTxnIntoAccount(txn *TxnRaw, ledger *Ledger) (*Account, error)
 	// get src
 	srcAcc, err := ledger.accounts.Get(int(txn.src_id))
 	if err != nil {
-		return nil, fmt.Errorf("_BlockRaw_AddTxnIntoAccount() get src_id failed: %w", err)
+		return nil, fmt.Errorf("_BlockRaw_AddTxnIntoAccount() get src_id failed: %w: %v", BlockRaw_ErrTxnRejected, err)
 	}
 
 	// check src
-	if srcAcc.nonce != txn.src_nonce {
-		return nil, errors.New("wrong nonce")
+	if txn.src_nonce > srcAcc.nonce {
+		return nil, BlockRaw_ErrTxnFuture
+	}
+	if txn.src_nonce < srcAcc.nonce {
+		return nil, fmt.Errorf("%w: wrong nonce", BlockRaw_ErrTxnRejected)
 	}
 	if srcAcc.amount < txn.amount {
-		return nil, errors.New("wron amount")
+		return nil, fmt.Errorf("%w: wrong amount", BlockRaw_ErrTxnRejected)
 	}
 
 	// get dst
@@ -99,10 +102,12 @@ func _BlockRaw_AddTxnIntoAccount(txn *TxnRaw, ledger *Ledger) (*Account, error)
 	}
 	dstAcc, err := ledger.accounts.Get(dst_i)
 	if err != nil {
-		return nil, fmt.Errorf("_BlockRaw_AddTxnIntoAccount() get dst_id failed: %w", err)
+		return nil, fmt.Errorf("_BlockRaw_AddTxnIntoAccount() get dst_id failed: %w: %v", BlockRaw_ErrTxnRejected, err)
 	}
 
 	//move
+	ledger.accounts.Backup(int(txn.src_id))
+	ledger.accounts.Backup(dst_i)
 	srcAcc.nonce++
 	srcAcc.amount -= txn.amount
 	dstAcc.amount += txn.amount
@@ -124,7 +129,7 @@ func (block *BlockRaw) AddTxn(txnBuff *TBuffer, max_block_size int, blockBuff *T
 	var txn TxnRaw
 	msg, pubKey, sign, err := txn.InitTxnFromBuffer(txnBuff, true, true)
 	if err != nil {
-		return false, fmt.Errorf("AddTxn().InitTxnFromBuffer() failed: %w", err)
+		return false, fmt.Errorf("AddTxn().InitTxnFromBuffer() failed: %w: %v", BlockRaw_ErrTxnRejected, err)
 	}
 
 	if int(blockBuff.size)+len(msg) > max_block_size {
@@ -133,17 +138,17 @@ func (block *BlockRaw) AddTxn(txnBuff *TBuffer, max_block_size int, blockBuff *T
 
 	account, err := ledger.accounts.Get(int(txn.src_id))
 	if err != nil {
-		return false, fmt.Errorf("AddTxn() get src_id failed: %w", err)
+		return false, fmt.Errorf("AddTxn() get src_id failed: %w: %v", BlockRaw_ErrTxnRejected, err)
 	}
 
 	var pk bls.PublicKey
 	err = account.pubKey.Export(&pk)
 	if err != nil {
-		return false, fmt.Errorf("AddTxn() src export pubKey failed: %w", err)
+		return false, fmt.Errorf("AddTxn() src export pubKey failed: %w: %v", BlockRaw_ErrTxnRejected, err)
 	}
 
 	if !pk.IsEqual(pubKey) {
-		return false, errors.New("AddTxn() PubKeys not match")
+		return false, fmt.Errorf("AddTxn() PubKeys not match: %w", BlockRaw_ErrTxnRejected)
 	}
 
 	_, err = _BlockRaw_AddTxnIntoAccount(&txn, ledger)
@@ -162,10 +167,10 @@ func (block *BlockRaw) AddTxn(txnBuff *TBuffer, max_block_size int, blockBuff *T
 }
 
 func (block *BlockRaw) Finish(blockBuff *TBuffer) error {
-	var aggSigns [BlockVerMT_NUM_AGG_SIGNITURES]bls.Sign
-	BlockVerMT_Sign(aggSigns[:], block)
+	aggSigns := make([]bls.Sign, BlockVerMT_NUM_AGG_SIGNITURES)
+	BlockVerMT_Sign(aggSigns, block)
 
-	var signs [BlockVerMT_NUM_AGG_SIGNITURES]BLSSign
+	signs := make([]BLSSign, BlockVerMT_NUM_AGG_SIGNITURES)
 	for i := 0; i < len(signs); i++ {
 		signs[i] = *NewBLSSign(&aggSigns[i])
 	}
@@ -181,26 +186,39 @@ func (block *BlockRaw) Finish(blockBuff *TBuffer) error {
 	return nil
 }
 
-func (block *BlockRaw) CheckAndWrite(blockBuff *TBuffer, ledger *Ledger) error {
+// block is invalid on its own(encoding, signitures), not only for current ledger state
+var BlockRaw_ErrInvalid = errors.New("invalid block")
+
+// txn failed checks before ledger was changed, block can continue without it
+var BlockRaw_ErrTxnRejected = errors.New("txn rejected")
+
+// txn nonce is ahead of account, txn can be added after previous txns of account
+var BlockRaw_ErrTxnFuture = errors.New("txn nonce is in future")
+
+// signCache(can be nil) has signitures verified on ingest, so they are not verified again
+func (block *BlockRaw) CheckAndWrite(blockBuff *TBuffer, ledger *Ledger, signCache *SignCache) error {
 
 	blockBuff.pos = 0
 
-	var aggSigns [BlockVerMT_NUM_AGG_SIGNITURES]bls.Sign
+	aggSigns := make([]bls.Sign, BlockVerMT_NUM_AGG_SIGNITURES)
 	for i := 0; i < len(aggSigns); i++ {
 
 		var sg BLSSign
 		err := blockBuff.ReadSBlob(sg.arr[:], int64(len(sg.arr)))
 		if err != nil {
-			return fmt.Errorf("CheckAndWrite() Buffer read failed: %w", err)
+			return fmt.Errorf("CheckAndWrite() Buffer read failed: %w: %v", BlockRaw_ErrInvalid, err)
 		}
 
 		err = sg.Export(&aggSigns[i])
 		if err != nil {
-			return fmt.Errorf("CheckAndWrite() aggsign export failed: %w", err)
+			return fmt.Errorf("CheckAndWrite() aggsign export failed: %w: %v", BlockRaw_ErrInvalid, err)
 		}
 	}
 
-	ledger.BatchStart()
+	err := ledger.BatchStart()
+	if err != nil {
+		return fmt.Errorf("CheckAndWrite() failed: %w", err)
+	}
 	block.Clear()
 
 	var absError error
@@ -208,7 +226,7 @@ func (block *BlockRaw) CheckAndWrite(blockBuff *TBuffer, ledger *Ledger) error {
 		var txn TxnRaw
 		msg, _, _, err := txn.InitTxnFromBuffer(blockBuff, false, false)
 		if err != nil {
-			absError = fmt.Errorf("CheckAndWrite() InitTxnFromBuffer() failed: %w", err)
+			absError = fmt.Errorf("CheckAndWrite() InitTxnFromBuffer() failed: %w: %v", BlockRaw_ErrInvalid, err)
 			break
 		}
 
@@ -233,19 +251,53 @@ func (block *BlockRaw) CheckAndWrite(blockBuff *TBuffer, ledger *Ledger) error {
 	}
 
 	if absError == nil {
-		err := BlockVerMT_Verify(aggSigns[:], block) // SLOWER(multi-threaded)
+		err := BlockVerMT_Verify(aggSigns, block, signCache) // SLOWER(multi-threaded)
 		//err := blsAggregateVerifyNoCheck(&aggSign, self.pubKeys, self.hashes, sizeof(OsHsh32), self.num_txns)
 		if err != nil {
-			absError = fmt.Errorf("CheckAndWrite() Verify() failed: %w", err)
+			absError = fmt.Errorf("CheckAndWrite() Verify() failed: %w: %v", BlockRaw_ErrInvalid, err)
 		}
 	}
 
 	if absError == nil {
-		ledger.BatchCommit()
-	} else {
+		_, err := ledger.AddBlock(blockBuff.data[:blockBuff.size], block.hashes)
+		if err != nil {
+			absError = fmt.Errorf("CheckAndWrite() AddBlock() failed: %w", err)
+		}
+	}
+
+	if absError == nil {
+		err := ledger.BatchCommit()
+		if err != nil {
+			absError = fmt.Errorf("CheckAndWrite() failed: %w", err)
+		}
+	}
+	if absError != nil {
 		ledger.BatchRollback()
-		ledger.accounts.Rollback()
 	}
 
 	return absError
 }
+
+// reads txns(without signitures) from block data
+func BlockRaw_ReadTxns(data []byte) ([]TxnRaw, [][]byte, error) {
+
+	buff := NewTBuffer(data)
+	buff.pos = int64(len(BLSSign{}.arr) * BlockVerMT_NUM_AGG_SIGNITURES)
+	if buff.pos > buff.size {
+		return nil, nil, errors.New("BlockRaw_ReadTxns() block is too short for agg signitures")
+	}
+
+	var txns []TxnRaw
+	var msgs [][]byte
+	for buff.pos < buff.size {
+		var txn TxnRaw
+		msg, _, _, err := txn.InitTxnFromBuffer(buff, false, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("BlockRaw_ReadTxns() InitTxnFromBuffer() failed: %w", err)
+		}
+		txns = append(txns, txn)
+		msgs = append(msgs, msg)
+	}
+
+	return txns, msgs, nil
+}
@@ -61,6 +61,22 @@ func (pubKey *BLSPubKey) Export(exp *bls.PublicKey) error {
 	return nil
 }
 
+// valid G1 point: on curve, in subgroup and not infinity
+func (pubKey *BLSPubKey) Check() error {
+	var pk bls.PublicKey
+	err := pubKey.Export(&pk)
+	if err != nil {
+		return fmt.Errorf("invalid G1 point: %w", err)
+	}
+	if pk.IsZero() {
+		return fmt.Errorf("G1 point is infinity")
+	}
+	if !pk.IsValidOrder() {
+		return fmt.Errorf("G1 point isn't in subgroup")
+	}
+	return nil
+}
+
 func (a *BLSPubKey) Cmp(b *BLSPubKey) bool {
 	return a.arr == b.arr
 }
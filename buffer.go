@@ -107,6 +107,10 @@ func (buff *TBuffer) ReadByte() (byte, error) {
 
 func (buff *TBuffer) ReadNumber() (int64, error) {
 
+	if buff.pos >= buff.size {
+		return 0, errors.New("ReadNumber() is is of buffer")
+	}
+
 	mask := uint8(buff.data[buff.pos])
 	buff.pos++
 
@@ -0,0 +1,1296 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const Cli_USAGE = `Usage: tin <command> [flags]
+
+Commands:
+  node      runs node
+  genesis   creates new chain in data dir
+  keygen    generates private key into keystore
+  key       lists, imports, exports and derives(mnemonic) keystore keys
+  send      sends txn to nodes
+  build     creates unsigned txn file(offline signing)
+  sign      signs unsigned txn file by keystore key
+  broadcast sends signed txn file to nodes
+  balance   prints balance and nonce of account
+  bench     generates txns, builds blocks from them and verifies blocks
+  replay    verifies blocks from file on fresh node
+  generate  regtest: node creates blocks now
+
+Run 'tin <command> -h' for command flags. Flags on command line have priority over -config file.
+`
+
+// flags shared by all commands
+type CliOptions struct {
+	config  string
+	network string
+	cfg     *NodeConfig
+	set     map[string]bool // flags from command line
+
+	data_dir string
+	port     int
+	peers    string // comma separated "host:port"
+
+	password_file string
+
+	tls        bool
+	tls_cert   string
+	tls_key    string
+	tls_ca     string
+	tls_pins   string // comma separated sha256 fingerprints
+	tls_mutual bool
+}
+
+func NewCliFlags(name string, opts *CliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	def := NetTLS_Default()
+	fs.StringVar(&opts.config, "config", "", "json config file")
+	fs.StringVar(&opts.network, "network", "mainnet", "parameter set: mainnet, testnet or regtest")
+	fs.StringVar(&opts.data_dir, "datadir", "", "directory for database, keys and genesis(default data, data/<network> for test networks)")
+	fs.IntVar(&opts.port, "port", 0, "node port(default from network)")
+	fs.StringVar(&opts.peers, "peers", "", "comma separated list of host:port. Node connects to them, client commands send to them(default localhost:port)")
+	fs.BoolVar(&opts.tls, "tls", false, "use TLS")
+	fs.StringVar(&opts.tls_cert, "tls-cert", def.cert_path, "TLS certificate")
+	fs.StringVar(&opts.tls_key, "tls-key", def.key_path, "TLS private key")
+	fs.StringVar(&opts.tls_ca, "tls-ca", "", "CA bundle for verifying remote certificate(default system roots)")
+	fs.StringVar(&opts.tls_pins, "tls-pin", "", "comma separated sha256 fingerprints of remote certificates")
+	fs.BoolVar(&opts.tls_mutual, "tls-mutual", false, "mutual TLS")
+	fs.StringVar(&opts.password_file, "password-file", "", "file with keystore password(default env TIN_PASSWORD or prompt)")
+	return fs
+}
+
+// parses flags, loads config file and activates network parameters
+func (opts *CliOptions) Parse(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	opts.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { opts.set[f.Name] = true })
+
+	opts.cfg = &NodeConfig{}
+	if len(opts.config) > 0 {
+		opts.cfg, err = NewNodeConfig(opts.config)
+		if err != nil {
+			return err
+		}
+	}
+	cfg := opts.cfg
+
+	if !opts.set["network"] && len(cfg.Network) > 0 {
+		opts.network = cfg.Network
+	}
+	params, err := cfg.NetParams(opts.network)
+	if err != nil {
+		return err
+	}
+	err = params.Apply()
+	if err != nil {
+		return err
+	}
+
+	if !opts.set["datadir"] {
+		opts.data_dir = cfg.DataDir
+		if len(opts.data_dir) == 0 {
+			opts.data_dir = "data"
+			if params.Name != "mainnet" {
+				opts.data_dir = filepath.Join("data", params.Name)
+			}
+		}
+	}
+	if !opts.set["port"] {
+		opts.port = OsTrn(cfg.Port > 0, cfg.Port, params.Port)
+	}
+	if !opts.set["peers"] && len(cfg.Peers) > 0 {
+		opts.peers = strings.Join(cfg.Peers, ",")
+	}
+	if !opts.set["tls"] && cfg.Tls != nil {
+		opts.tls = true
+		if !opts.set["tls-cert"] && len(cfg.Tls.Cert) > 0 {
+			opts.tls_cert = cfg.Tls.Cert
+		}
+		if !opts.set["tls-key"] && len(cfg.Tls.Key) > 0 {
+			opts.tls_key = cfg.Tls.Key
+		}
+		if !opts.set["tls-ca"] {
+			opts.tls_ca = cfg.Tls.Ca
+		}
+		if !opts.set["tls-pin"] {
+			opts.tls_pins = strings.Join(cfg.Tls.Pins, ",")
+		}
+		if !opts.set["tls-mutual"] {
+			opts.tls_mutual = cfg.Tls.Mutual
+		}
+	}
+	return nil
+}
+
+// returns nil without -tls
+func (opts *CliOptions) TLS() (*NetTLS, error) {
+	if !opts.tls {
+		return nil, nil
+	}
+
+	conf := NetTLS{cert_path: opts.tls_cert, key_path: opts.tls_key, ca_path: opts.tls_ca, mutual: opts.tls_mutual}
+	for _, str := range _Cli_list(opts.tls_pins) {
+		pin, err := NetTLS_ParsePin(str)
+		if err != nil {
+			return nil, err
+		}
+		conf.pins = append(conf.pins, pin)
+	}
+	return &conf, nil
+}
+
+func (opts *CliOptions) Peers() []string {
+	return _Cli_list(opts.peers)
+}
+
+// peers for client commands
+func (opts *CliOptions) Nodes() []string {
+	nodes := opts.Peers()
+	if len(nodes) == 0 {
+		nodes = append(nodes, "localhost:"+strconv.Itoa(opts.port))
+	}
+	return nodes
+}
+
+func (opts *CliOptions) DbPath() string {
+	return filepath.Join(opts.data_dir, "db.sqlite")
+}
+
+func (opts *CliOptions) GenesisPath() string {
+	return filepath.Join(opts.data_dir, NetParams_Active.Genesis)
+}
+
+func (opts *CliOptions) Keystore() (*Keystore, error) {
+	return NewKeystore(filepath.Join(opts.data_dir, "keystore"))
+}
+
+func _Cli_list(str string) []string {
+	var ret []string
+	for _, it := range strings.Split(str, ",") {
+		it = strings.TrimSpace(it)
+		if len(it) > 0 {
+			ret = append(ret, it)
+		}
+	}
+	return ret
+}
+
+// runs command, args are without program name
+func Cli_Run(args []string) error {
+
+	if len(args) == 0 {
+		fmt.Print(Cli_USAGE)
+		return errors.New("missing command")
+	}
+
+	cmd := args[0]
+	args = args[1:]
+
+	var err error
+	switch cmd {
+	case "node":
+		err = Cli_node(args)
+	case "genesis":
+		err = Cli_genesis(args)
+	case "keygen":
+		err = Cli_keygen(args)
+	case "key":
+		err = Cli_key(args)
+	case "send":
+		err = Cli_send(args)
+	case "build":
+		err = Cli_build(args)
+	case "sign":
+		err = Cli_sign(args)
+	case "broadcast":
+		err = Cli_broadcast(args)
+	case "balance":
+		err = Cli_balance(args)
+	case "bench":
+		err = Cli_bench(args)
+	case "replay":
+		err = Cli_replay(args)
+	case "generate":
+		err = Cli_generate(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(Cli_USAGE)
+	default:
+		fmt.Print(Cli_USAGE)
+		err = fmt.Errorf("unknown command '%s'", cmd)
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return nil // usage was printed by FlagSet
+	}
+	return err
+}
+
+// private key file is hex
+func Cli_ReadKey(path string) (*BLSPrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cli_ReadKey() ReadFile() failed: %w", err)
+	}
+	var key BLSPrivKey
+	arr, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(arr) != len(key.arr) {
+		return nil, fmt.Errorf("Cli_ReadKey() %s is not a valid key", path)
+	}
+	key.arr = [32]byte(arr)
+	return &key, nil
+}
+
+func Cli_WriteKey(path string, key *BLSPrivKey) error {
+	if OsFileExists(path) {
+		return fmt.Errorf("Cli_WriteKey() %s already exists", path)
+	}
+	err := os.WriteFile(path, []byte(hex.EncodeToString(key.arr[:])), 0600)
+	if err != nil {
+		return fmt.Errorf("Cli_WriteKey() WriteFile() failed: %w", err)
+	}
+	return nil
+}
+
+var _Cli_stdin = bufio.NewReader(os.Stdin)
+
+// prompt goes to stderr, so stdout can be redirected
+func _Cli_readLine(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := _Cli_stdin.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return nil, fmt.Errorf("_Cli_readLine() failed: %w", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// secret isn't echoed on terminal, piped input is read as line
+func _Cli_readSecret(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return _Cli_readLine(prompt)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("_Cli_readSecret() failed: %w", err)
+	}
+	return secret, nil
+}
+
+// password from -password-file, TIN_PASSWORD or terminal. New password(confirm) is asked twice
+func Cli_ReadPassword(opts *CliOptions, confirm bool) ([]byte, error) {
+	if len(opts.password_file) > 0 {
+		data, err := os.ReadFile(opts.password_file)
+		if err != nil {
+			return nil, fmt.Errorf("Cli_ReadPassword() ReadFile() failed: %w", err)
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+	if env, found := os.LookupEnv("TIN_PASSWORD"); found {
+		return []byte(env), nil
+	}
+
+	password, err := _Cli_readSecret("Password: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, errors.New("Cli_ReadPassword() password is empty")
+	}
+	if confirm {
+		again, err := _Cli_readSecret("Repeat password: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(password, again) {
+			return nil, errors.New("Cli_ReadPassword() passwords don't match")
+		}
+	}
+	return password, nil
+}
+
+// imports key into keystore, asks for new password
+func _Cli_storeKey(opts *CliOptions, privKey *BLSPrivKey, name string) error {
+	ks, err := opts.Keystore()
+	if err != nil {
+		return err
+	}
+	password, err := Cli_ReadPassword(opts, true)
+	if err != nil {
+		return err
+	}
+	path, err := ks.Import(privKey, password, name)
+	if err != nil {
+		return err
+	}
+
+	var pubKey BLSPubKey
+	err = privKey.ExportPublicKey(&pubKey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Key written into %s\naddress: %s\npubKey: %x\n", path, NewAddressPubKey(&pubKey), pubKey.arr)
+	return nil
+}
+
+// pubKey of keystore key, password is not needed
+func _Cli_keystorePubKey(opts *CliOptions, id string) (*BLSPubKey, error) {
+	ks, err := opts.Keystore()
+	if err != nil {
+		return nil, err
+	}
+	kf, err := ks.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	return kf.GetPubKey()
+}
+
+func _Cli_readGenesis(opts *CliOptions) (*Genesis, error) {
+	genesis, err := Genesis_Load(opts.GenesisPath())
+	if err != nil {
+		return nil, fmt.Errorf("%w(run 'tin genesis' first)", err)
+	}
+	return genesis, nil
+}
+
+func _Cli_waitSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+}
+
+func Cli_node(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("node", &opts)
+	seeds := fs.String("seeds", "", "comma separated seed nodes(host:port) for address book")
+	txns_in_block := fs.Int("txns-in-block", 10000, "max number of txns in created block")
+	blocksPath := fs.String("blocks", "", "appends created blocks into file(for replay)")
+	reset := fs.Bool("reset", false, "regtest: deletes database, address book and bans before start")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *reset {
+		err = _Cli_reset(&opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	genesis, err := _Cli_readGenesis(&opts)
+	if err != nil {
+		return err
+	}
+	tlsConf, err := opts.TLS()
+	if err != nil {
+		return err
+	}
+
+	if !opts.set["seeds"] && len(opts.cfg.Seeds) > 0 {
+		*seeds = strings.Join(opts.cfg.Seeds, ",")
+	}
+	if !opts.set["txns-in-block"] && opts.cfg.TxnsInBlock > 0 {
+		*txns_in_block = opts.cfg.TxnsInBlock
+	}
+	if !opts.set["blocks"] && len(opts.cfg.BlocksPath) > 0 {
+		*blocksPath = opts.cfg.BlocksPath
+	}
+
+	node, err := NewNode(tlsConf, opts.port, opts.Peers(), _Cli_list(*seeds), opts.DbPath(), *txns_in_block, genesis, *blocksPath, opts.cfg.NetLimits())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Node(%s) is running on port %d, Ctrl+C stops it\n", NetParams_Active.Name, opts.port)
+
+	_Cli_waitSignal()
+	node.Destroy()
+	return nil
+}
+
+// deletes chain state of regtest, genesis and keys are kept
+func _Cli_reset(opts *CliOptions) error {
+	if !NetParams_Active.Regtest {
+		return errors.New("-reset is only for regtest")
+	}
+
+	paths, err := filepath.Glob(opts.DbPath() + "*") // with sqlite journal
+	if err != nil {
+		return err
+	}
+	paths = append(paths, filepath.Join(opts.data_dir, "peers.json"), filepath.Join(opts.data_dir, "banlist.json"))
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("_Cli_reset() failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func Cli_genesis(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("genesis", &opts)
+	allocStr := fs.String("alloc", "", "comma separated allocations <address>:<amount>")
+	amount := fs.Int64("amount", 100000000, "amount of genesis account, when -alloc is not set")
+	from := fs.String("from", "", "keystore key of genesis account, when -alloc is not set(default new key named 'genesis')")
+	timestamp := fs.Int64("timestamp", 0, "unix time(default now, regtest has fixed time)")
+	difficulty := fs.Int("difficulty", -1, "initial difficulty bits(default 0 for regtest)")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if OsFileExists(opts.GenesisPath()) {
+		return fmt.Errorf("genesis %s already exists", opts.GenesisPath())
+	}
+	err = os.MkdirAll(opts.data_dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	var alloc []GenesisAlloc
+	for _, it := range _Cli_list(*allocStr) {
+		addrStr, amountStr, found := strings.Cut(it, ":")
+		value, err := strconv.ParseInt(amountStr, 10, 64)
+		if !found || err != nil {
+			return fmt.Errorf("invalid allocation(%s), expected <address>:<amount>", it)
+		}
+		addr, err := Address_Parse(addrStr)
+		if err != nil {
+			return err
+		}
+		if addr.IsShort() {
+			return fmt.Errorf("allocation(%s) needs pubKey address, not short id", it)
+		}
+		alloc = append(alloc, GenesisAlloc{PubKey: hex.EncodeToString(addr.pubKey.arr[:]), Amount: value})
+	}
+
+	if len(alloc) == 0 {
+		var pubKey *BLSPubKey
+		if len(*from) > 0 {
+			pubKey, err = _Cli_keystorePubKey(&opts, *from)
+			if err != nil {
+				return err
+			}
+		} else {
+			cl, err := NewClientAccount(nil)
+			if err != nil {
+				return err
+			}
+			err = _Cli_storeKey(&opts, NewBLSPrivKey(&cl.key), "genesis")
+			if err != nil {
+				return err
+			}
+			pubKey = &cl.pubKey
+		}
+		alloc = append(alloc, GenesisAlloc{PubKey: hex.EncodeToString(pubKey.arr[:]), Amount: *amount})
+	}
+
+	if *timestamp == 0 {
+		*timestamp = time.Now().Unix()
+		if NetParams_Active.Regtest {
+			*timestamp = Node_REGTEST_TIME
+		}
+	}
+	if *difficulty < 0 {
+		*difficulty = Genesis_DIFFICULTY
+		if NetParams_Active.Regtest {
+			*difficulty = 0
+		}
+	}
+
+	genesis := NewGenesis(*timestamp, uint32(*difficulty), alloc)
+	err = genesis.Check()
+	if err != nil {
+		return err
+	}
+	err = genesis.Save(opts.GenesisPath())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Genesis %s written, hash: %x\n", opts.GenesisPath(), genesis.Hash())
+	return nil
+}
+
+func Cli_keygen(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("keygen", &opts)
+	name := fs.String("name", "", "name of key in keystore")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	cl, err := NewClientAccount(nil)
+	if err != nil {
+		return err
+	}
+	return _Cli_storeKey(&opts, NewBLSPrivKey(&cl.key), *name)
+}
+
+// tin key list|import|export
+func Cli_key(args []string) error {
+	if len(args) == 0 {
+		return errors.New("key needs subcommand: list, import, export, mnemonic or derive")
+	}
+
+	var opts CliOptions
+	fs := NewCliFlags("key "+args[0], &opts)
+	file := fs.String("file", "", "import: hex private key file")
+	name := fs.String("name", "", "import, derive: name of key in keystore(derive adds -<account> with more accounts)")
+	from := fs.String("from", "", "export: keystore key(name or hex pubKey prefix)")
+	out := fs.String("out", "", "export: output file for hex private key(default stdout)")
+	mnemonicFile := fs.String("mnemonic-file", "", "derive: file with mnemonic(default prompt)")
+	passphrase := fs.String("passphrase", "", "derive: BIP-39 passphrase of mnemonic")
+	account := fs.Uint("account", 0, "derive: first account index, key path is m/12381/4879/<account>/0")
+	count := fs.Uint("count", 1, "derive: number of accounts")
+	path := fs.String("path", "", "derive: custom key path, e.g. m/12381/4879/0/0")
+	err := opts.Parse(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	ks, err := opts.Keystore()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		list, err := ks.List()
+		if err != nil {
+			return err
+		}
+		for _, kf := range list {
+			name := kf.Name
+			if len(name) == 0 {
+				name = "-"
+			}
+			pubKey, err := kf.GetPubKey()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-16s %s %s\n", name, NewAddressPubKey(pubKey), kf.PubKey)
+		}
+
+	case "import":
+		if len(*file) == 0 {
+			return errors.New("key import needs -file")
+		}
+		privKey, err := Cli_ReadKey(*file)
+		if err != nil {
+			return err
+		}
+		return _Cli_storeKey(&opts, privKey, *name)
+
+	case "export":
+		if len(*from) == 0 {
+			return errors.New("key export needs -from")
+		}
+		password, err := Cli_ReadPassword(&opts, false)
+		if err != nil {
+			return err
+		}
+		privKey, err := ks.Export(*from, password)
+		if err != nil {
+			return err
+		}
+		if len(*out) == 0 {
+			fmt.Println(hex.EncodeToString(privKey.arr[:]))
+			return nil
+		}
+		err = Cli_WriteKey(*out, privKey)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Key written into %s\n", *out)
+
+	case "mnemonic":
+		mnemonic, err := HDKey_NewMnemonic()
+		if err != nil {
+			return err
+		}
+		fmt.Println(mnemonic)
+		fmt.Fprintln(os.Stderr, "Write mnemonic down, all derived keys can be recovered from it. Keys are created by 'tin key derive'")
+
+	case "derive":
+		var mnemonic []byte
+		if len(*mnemonicFile) > 0 {
+			mnemonic, err = os.ReadFile(*mnemonicFile)
+		} else {
+			mnemonic, err = _Cli_readSecret("Mnemonic: ")
+		}
+		if err != nil {
+			return err
+		}
+		seed, err := HDKey_MnemonicSeed(string(mnemonic), *passphrase)
+		if err != nil {
+			return err
+		}
+
+		var paths []string
+		if len(*path) > 0 {
+			paths = append(paths, *path)
+		} else {
+			for i := uint(0); i < *count; i++ {
+				paths = append(paths, HDKey_AccountPath(uint32(*account+i)))
+			}
+		}
+
+		password, err := Cli_ReadPassword(&opts, true)
+		if err != nil {
+			return err
+		}
+		for i, p := range paths {
+			privKey, err := HDKey_Derive(seed, p)
+			if err != nil {
+				return err
+			}
+			nm := *name
+			if len(nm) > 0 && len(paths) > 1 {
+				nm = fmt.Sprintf("%s-%d", nm, *account+uint(i))
+			}
+			file, err := ks.Import(privKey, password, nm)
+			if err != nil {
+				return err
+			}
+			var pubKey BLSPubKey
+			err = privKey.ExportPublicKey(&pubKey)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s %s %s\n", p, NewAddressPubKey(&pubKey), file)
+		}
+
+	default:
+		return fmt.Errorf("unknown key subcommand '%s'", args[0])
+	}
+	return nil
+}
+
+func Cli_send(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("send", &opts)
+	from := fs.String("from", "", "keystore key of sender(name or hex pubKey prefix)")
+	to := fs.String("to", "", "receiver: address or short id")
+	amount := fs.Int64("amount", 0, "amount")
+	fee := fs.Int64("fee", 0, "fee")
+	src_id := fs.Int64("src", -1, "account id of sender(default is asked from node)")
+	nonce := fs.Int64("nonce", -1, "nonce(default is asked from node)")
+	wait := fs.Bool("wait", true, "waits for nodes acks")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(*from) == 0 || len(*to) == 0 || *amount <= 0 {
+		return errors.New("send needs -from, -to and -amount")
+	}
+	dst, err := Address_Parse(*to)
+	if err != nil {
+		return err
+	}
+
+	ks, err := opts.Keystore()
+	if err != nil {
+		return err
+	}
+	password, err := Cli_ReadPassword(&opts, false)
+	if err != nil {
+		return err
+	}
+	src, err := ks.Unlock(*from, password)
+	if err != nil {
+		return err
+	}
+	tlsConf, err := opts.TLS()
+	if err != nil {
+		return err
+	}
+
+	if *src_id < 0 || *nonce < 0 {
+		*src_id, *nonce, err = _Cli_getNonce(&opts, tlsConf, &src.pubKey, *src_id, *nonce)
+		if err != nil {
+			return err
+		}
+	}
+
+	var txn TxnRaw
+	if dst.IsShort() {
+		txn.InitTxnRawShort(*src_id, *nonce, *amount, *fee, dst.id)
+	} else {
+		txn.InitTxnRawLong(*src_id, *nonce, *amount, *fee, dst.pubKey)
+	}
+
+	var buff TBuffer
+	err = txn.ExportBuffer(&src.pubKey, &src.key, &buff)
+	if err != nil {
+		return err
+	}
+	return _Cli_broadcast(&opts, tlsConf, buff.data[:buff.size], *wait)
+}
+
+// src id and nonce from node, values >= 0 are kept
+func _Cli_getNonce(opts *CliOptions, tlsConf *NetTLS, pubKey *BLSPubKey, src_id int64, nonce int64) (int64, int64, error) {
+	var acc RpcAccount
+	err := Rpc_Call(opts.Nodes()[0], tlsConf, "getAccountByPubKey", &RpcAccountParams{PubKey: hex.EncodeToString(pubKey.arr[:])}, &acc)
+	if err != nil {
+		return -1, -1, err
+	}
+	if src_id < 0 {
+		src_id = acc.Id
+	}
+	if nonce < 0 {
+		nonce = acc.Nonce
+	}
+	return src_id, nonce, nil
+}
+
+// sends signed txn to all nodes
+func _Cli_broadcast(opts *CliOptions, tlsConf *NetTLS, data []byte, wait bool) error {
+	genesis, err := _Cli_readGenesis(opts)
+	if err != nil {
+		return err
+	}
+	conns := NewConnections(NewNetHello(genesis.ChainId, genesis.Hash(), -1, 0, rand.Int63(), nil), Connections_BROADCAST)
+	defer conns.Destroy()
+	for _, addr := range opts.Nodes() {
+		host, port, err := _Cli_splitAddr(addr)
+		if err != nil {
+			return err
+		}
+		err = conns.Add(host, port, "data", tlsConf)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = conns.SendTxn(data, wait)
+	if err != nil {
+		return err
+	}
+
+	id, err := Server_TxnId(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Txn %x sent\n", id)
+	return nil
+}
+
+// online machine: creates unsigned txn file
+func Cli_build(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("build", &opts)
+	from := fs.String("from", "", "sender: address or keystore key(password is not needed)")
+	to := fs.String("to", "", "receiver: address or short id")
+	amount := fs.Int64("amount", 0, "amount")
+	fee := fs.Int64("fee", 0, "fee")
+	src_id := fs.Int64("src", -1, "account id of sender(default is asked from node)")
+	nonce := fs.Int64("nonce", -1, "nonce(default is asked from node)")
+	out := fs.String("out", "txn.json", "unsigned txn file")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(*from) == 0 || len(*to) == 0 || *amount <= 0 {
+		return errors.New("build needs -from, -to and -amount")
+	}
+	dst, err := Address_Parse(*to)
+	if err != nil {
+		return err
+	}
+
+	var pubKey *BLSPubKey
+	if addr, err := Address_Parse(*from); err == nil && !addr.IsShort() {
+		pubKey = addr.pubKey
+	} else {
+		pubKey, err = _Cli_keystorePubKey(&opts, *from)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *src_id < 0 || *nonce < 0 {
+		tlsConf, err := opts.TLS()
+		if err != nil {
+			return err
+		}
+		*src_id, *nonce, err = _Cli_getNonce(&opts, tlsConf, pubKey, *src_id, *nonce)
+		if err != nil {
+			return err
+		}
+	}
+
+	ut := NewTxnUnsigned(pubKey, *src_id, *nonce, *amount, *fee, dst)
+	err = ut.Check()
+	if err != nil {
+		return err
+	}
+	err = ut.Save(*out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\nUnsigned txn written into %s\n", ut, *out)
+	return nil
+}
+
+// offline machine: signs txn file by keystore key
+func Cli_sign(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("sign", &opts)
+	in := fs.String("in", "txn.json", "unsigned txn file")
+	from := fs.String("from", "", "keystore key(default key of txn's 'from')")
+	out := fs.String("out", "", "output file for hex of signed txn(default stdout)")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	ut, err := TxnUnsigned_Load(*in)
+	if err != nil {
+		return err
+	}
+	err = ut.Check()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", ut)
+
+	id := *from
+	if len(id) == 0 {
+		pubKey, _ := ut.FromPubKey()
+		id = hex.EncodeToString(pubKey.arr[:])
+	}
+	ks, err := opts.Keystore()
+	if err != nil {
+		return err
+	}
+	password, err := Cli_ReadPassword(&opts, false)
+	if err != nil {
+		return err
+	}
+	acc, err := ks.Unlock(id, password)
+	if err != nil {
+		return err
+	}
+
+	data, err := ut.Sign(acc)
+	if err != nil {
+		return err
+	}
+	if len(*out) == 0 {
+		fmt.Println(hex.EncodeToString(data))
+		return nil
+	}
+	if OsFileExists(*out) {
+		return fmt.Errorf("%s already exists", *out)
+	}
+	err = os.WriteFile(*out, []byte(hex.EncodeToString(data)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Signed txn written into %s\n", *out)
+	return nil
+}
+
+// online machine: checks signed txn and sends it to nodes
+func Cli_broadcast(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("broadcast", &opts)
+	in := fs.String("in", "", "file with hex of signed txn")
+	wait := fs.Bool("wait", true, "waits for nodes acks")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(*in) == 0 {
+		return errors.New("broadcast needs -in")
+	}
+
+	data, err := TxnUnsigned_ReadSigned(*in)
+	if err != nil {
+		return err
+	}
+	ut, _, err := TxnUnsigned_FromSigned(data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", ut)
+
+	tlsConf, err := opts.TLS()
+	if err != nil {
+		return err
+	}
+	return _Cli_broadcast(&opts, tlsConf, data, *wait)
+}
+
+func _Cli_splitAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address(%s): %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in address(%s)", addr)
+	}
+	return host, port, nil
+}
+
+func Cli_balance(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("balance", &opts)
+	address := fs.String("address", "", "address or short id of account")
+	pubKey := fs.String("pubkey", "", "hex pubKey of account")
+	id := fs.Int64("id", -1, "account id")
+	from := fs.String("from", "", "keystore key(name or hex pubKey prefix)")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+
+	var params RpcAccountParams
+	if len(*address) > 0 {
+		_, err = Address_Parse(*address) // typo is reported before asking node
+		if err != nil {
+			return err
+		}
+		params.Address = *address
+	} else if *id >= 0 {
+		params.Id = id
+	} else if len(*pubKey) > 0 {
+		params.PubKey = *pubKey
+	} else if len(*from) > 0 {
+		pub, err := _Cli_keystorePubKey(&opts, *from)
+		if err != nil {
+			return err
+		}
+		params.PubKey = hex.EncodeToString(pub.arr[:])
+	} else {
+		return errors.New("balance needs -address, -id, -pubkey or -from")
+	}
+
+	tlsConf, err := opts.TLS()
+	if err != nil {
+		return err
+	}
+
+	var acc RpcAccount
+	err = Rpc_Call(opts.Nodes()[0], tlsConf, "getAccount", &params, &acc)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Account: %d(%s)\nAddress: %s\nPubKey: %s\nAmount: %d\nNonce: %d\n", acc.Id, acc.ShortId, acc.Address, acc.PubKey, acc.Amount, acc.Nonce)
+	return nil
+}
+
+// bench and replay run nodes in temp dir(no address book, banlist, node key) and on free port(unless -port is set), so running node isn't touched
+func _Cli_tempDir(opts *CliOptions, name string) (string, error) {
+	dir, err := os.MkdirTemp("", "tin-"+name+"-")
+	if err != nil {
+		return "", fmt.Errorf("%s MkdirTemp() failed: %w", name, err)
+	}
+
+	if !opts.set["port"] {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("%s Listen() failed: %w", name, err)
+		}
+		opts.port = l.Addr().(*net.TCPAddr).Port
+		l.Close()
+	}
+	return dir, nil
+}
+
+const Cli_LISTEN_TIMEOUT = 10 * time.Second
+
+// node listens from own goroutine, so it's dialed until it accepts connection
+func _Cli_waitListen(port int) error {
+	addr := net.JoinHostPort("localhost", strconv.Itoa(port))
+	start := time.Now()
+	for {
+		c, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			c.Close()
+			return nil
+		}
+		if time.Since(start) > Cli_LISTEN_TIMEOUT {
+			return fmt.Errorf("_Cli_waitListen() node isn't listening on %s: %w", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// generates txns, sends them into node which builds blocks, then other node verifies these blocks
+func Cli_bench(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("bench", &opts)
+	num_txns := fs.Int("txns", 40000, "number of txns")
+	txns_in_block := fs.Int("txns-in-block", 10000, "number of txns in block")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *txns_in_block <= 0 || *num_txns < *txns_in_block {
+		return errors.New("-txns must be >= -txns-in-block")
+	}
+
+	NUMBER_TXNS := *num_txns
+	NUMBER_TXNS_IN_BLOCK := *txns_in_block
+
+	//file paths, bench doesn't touch data dir of node
+	dir, err := _Cli_tempDir(&opts, "bench")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	dbPathA := filepath.Join(dir, "A", "db.sqlite")
+	dbPathB := filepath.Join(dir, "B", "db.sqlite")
+	txnsPath := filepath.Join(dir, "txns_")
+	blocksPath := filepath.Join(dir, "blocks.bin")
+	os.MkdirAll(filepath.Dir(dbPathA), os.ModePerm)
+	os.MkdirAll(filepath.Dir(dbPathB), os.ModePerm)
+
+	tlsConf, err := opts.TLS()
+	if err != nil {
+		return err
+	}
+
+	// inits Db
+	genesis_amount := int64(Client_BENCH_GENESIS_AMOUNT)
+	genesis_privKey, err := Client_benchGenesis()
+	if err != nil {
+		return err
+	}
+	var genesis_pubKey BLSPubKey
+	genesis_privKey.ExportPublicKey(&genesis_pubKey)
+	genesis := NewGenesis(Node_REGTEST_TIME, 0, []GenesisAlloc{{PubKey: hex.EncodeToString(genesis_pubKey.arr[:]), Amount: genesis_amount}})
+	hello := NewNetHello(genesis.ChainId, genesis.Hash(), -1, 0, rand.Int63(), nil)
+
+	// generates txns into write them into file
+	{
+		err = Client_generateTxnsFile(NUMBER_TXNS, NUMBER_TXNS_IN_BLOCK, genesis_amount, genesis_privKey, txnsPath)
+		if err != nil {
+			return fmt.Errorf("Client_generateTxnsFile() failed: %w", err)
+		}
+	}
+
+	// benchmark floods txns from localhost
+	limits := opts.cfg.NetLimits()
+	limits.txns_per_sec_conn = 0
+	limits.txns_per_sec_ip = 0
+	// recvs txns and build blocks
+	{
+		node, err := NewNode(tlsConf, opts.port, nil, nil, dbPathA, NUMBER_TXNS_IN_BLOCK, genesis, blocksPath, limits) //blocksPath=write blocks into file
+		if err != nil {
+			return fmt.Errorf("NewNode() failed: %w", err)
+		}
+
+		err = _Cli_waitListen(opts.port)
+		if err != nil {
+			node.Destroy()
+			return err
+		}
+		var conns []*Connections
+		for i := 0; i < runtime.NumCPU(); i++ {
+			conns = append(conns, NewConnections(hello, Connections_BROADCAST))
+			err = conns[i].Add("localhost", opts.port, "data", tlsConf)
+			if err != nil {
+				return fmt.Errorf("Connections.Add() failed: %w", err)
+			}
+		}
+
+		n, err := Client_sendTxns(conns[0], txnsPath+"0")
+		if err != nil {
+			return fmt.Errorf("Client_sendTxns() failed: %w", err)
+		}
+		nn := n
+		for node.stat.sum_txns < nn {
+			time.Sleep(1 * time.Millisecond)
+		}
+
+		for bi := 0; bi < (NUMBER_TXNS/NUMBER_TXNS_IN_BLOCK)-1; bi++ {
+			time.Sleep(1 * time.Second) //? ...
+			n, err := Client_sendTxnsMT(conns, txnsPath+strconv.Itoa(bi+1))
+			if err != nil {
+				return fmt.Errorf("Client_sendTxns() failed: %w", err)
+			}
+			nn += n
+			for node.stat.sum_txns < nn {
+				time.Sleep(1 * time.Millisecond)
+			}
+		}
+
+		for _, c := range conns {
+			c.Destroy()
+		}
+		node.Destroy()
+	}
+
+	// recvs blocks and verify them
+	{
+		node, err := NewNode(tlsConf, opts.port, nil, nil, dbPathB, NUMBER_TXNS_IN_BLOCK, genesis, "", limits)
+		if err != nil {
+			return fmt.Errorf("NewNode() failed: %w", err)
+		}
+		err = _Cli_waitListen(opts.port)
+		if err != nil {
+			node.Destroy()
+			return err
+		}
+
+		conns := NewConnections(hello, Connections_BROADCAST)
+		err = conns.Add("localhost", opts.port, "data", tlsConf)
+		if err != nil {
+			return fmt.Errorf("Connections.Add() failed: %w", err)
+		}
+
+		n, err := Client_sendBlocks(conns, blocksPath)
+		if err != nil {
+			return fmt.Errorf("Client_sendBlocks() failed: %w", err)
+		}
+
+		for node.stat.num_blocks < n {
+			time.Sleep(1 * time.Millisecond)
+		}
+
+		conns.Destroy()
+		node.Destroy()
+	}
+	return nil
+}
+
+// starts node with empty database and verifies blocks from file(written by 'node -blocks')
+func Cli_replay(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("replay", &opts)
+	blocksPath := fs.String("blocks", "", "blocks file")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(*blocksPath) == 0 {
+		return errors.New("replay needs -blocks")
+	}
+
+	genesis, err := _Cli_readGenesis(&opts)
+	if err != nil {
+		return err
+	}
+	tlsConf, err := opts.TLS()
+	if err != nil {
+		return err
+	}
+
+	dir, err := _Cli_tempDir(&opts, "replay")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "db.sqlite")
+	node, err := NewNode(tlsConf, opts.port, nil, nil, dbPath, 0, genesis, "", opts.cfg.NetLimits())
+	if err != nil {
+		return err
+	}
+	defer node.Destroy()
+	err = _Cli_waitListen(opts.port)
+	if err != nil {
+		return err
+	}
+
+	hello := NewNetHello(node.network_id, node.genesis_hash, -1, 0, rand.Int63(), nil)
+	conns := NewConnections(hello, Connections_BROADCAST)
+	defer conns.Destroy()
+	err = conns.Add("localhost", opts.port, "data", tlsConf)
+	if err != nil {
+		return err
+	}
+
+	st := OsTime()
+	n, err := Client_sendBlocks(conns, *blocksPath)
+	if err != nil {
+		return err
+	}
+	for node.stat.num_blocks < n {
+		time.Sleep(1 * time.Millisecond)
+	}
+	fmt.Printf("%d blocks verified in %.1fsec\n", n, OsTime()-st)
+	return nil
+}
+
+func Cli_generate(args []string) error {
+	var opts CliOptions
+	fs := NewCliFlags("generate", &opts)
+	num := fs.Int("blocks", 1, "number of blocks")
+	address := fs.String("address", "", "reward address or short id(optional)")
+	err := opts.Parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(*address) > 0 {
+		_, err = Address_Parse(*address)
+		if err != nil {
+			return err
+		}
+	}
+	tlsConf, err := opts.TLS()
+	if err != nil {
+		return err
+	}
+
+	var blocks []RpcGenerated
+	err = Rpc_Call(opts.Nodes()[0], tlsConf, "generate", &RpcGenerateParams{Blocks: *num, Address: *address}, &blocks)
+	if err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		fmt.Printf("%d %s\n", b.Height, b.Hash)
+	}
+	return nil
+}
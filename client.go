@@ -81,54 +81,15 @@ func Client_WriteInt(file *os.File, value int64) error {
 
 }
 
-func Client_getOrGenerateGenesis(genesisPath string, amount *int64, privKey *BLSPrivKey) error {
+// bench only: genesis account with key in memory(never written to disk). Chain definition is Genesis(genesis.json)
+const Client_BENCH_GENESIS_AMOUNT = 100000000
 
-	if OsFileExists(genesisPath) {
-		//opens
-		data, err := os.ReadFile(genesisPath)
-		if err != nil {
-			return fmt.Errorf("Client_getOrGenerateGenesis() ReadFile() failed: %w", err)
-		}
-
-		//reads
-		pos := 0
-		*amount = int64(binary.LittleEndian.Uint64(data[pos : pos+8]))
-		pos += 8
-
-		copy(privKey.arr[:], data[pos:pos+len(privKey.arr)])
-
-		fmt.Printf("Reading genesis file(%s)\n", genesisPath)
-	} else {
-
-		// generates
-		*amount = 100000000
-		cl, err := NewClientAccount(nil)
-		if err != nil {
-			return fmt.Errorf("Client_getOrGenerateGenesis() NewClientAccount() failed: %w", err)
-		}
-		*privKey = *NewBLSPrivKey(&cl.key)
-		cl = nil
-
-		// writes
-		f, err := os.Create(genesisPath)
-		if err != nil {
-			return fmt.Errorf("Client_getOrGenerateGenesis() Create() failed: %w", err)
-		}
-		defer f.Close()
-
-		err = Client_WriteInt(f, *amount)
-		if err != nil {
-			return fmt.Errorf("Client_getOrGenerateGenesis() Client_WriteInt() failed: %w", err)
-		}
-		_, err = f.Write(privKey.arr[:])
-		if err != nil {
-			return fmt.Errorf("Client_getOrGenerateGenesis() Write() failed: %w", err)
-		}
-
-		fmt.Printf("Genesis file(%s) written\n", genesisPath)
+func Client_benchGenesis() (*BLSPrivKey, error) {
+	cl, err := NewClientAccount(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Client_benchGenesis() NewClientAccount() failed: %w", err)
 	}
-
-	return nil
+	return NewBLSPrivKey(&cl.key), nil
 }
 
 func Client_generateTxnsFile(NUMBER_TXNS int, NUMBER_TXNS_IN_BLOCK int, genesis_amount int64, genesis_privKey *BLSPrivKey, txnsPath string) error {
@@ -309,7 +270,7 @@ func Client_sendTxns(cons *Connections, txnsPath string) (int, error) {
 		pos += 8
 
 		//node.net.txnsPool.Add(data[pos : pos+bytes])
-		err := cons.SendTxn(data[pos : pos+bytes])
+		err := cons.SendTxn(data[pos:pos+bytes], false)
 		if err != nil {
 			log.Printf("Client_sendTxns() failed: %v\n", err)
 		}
@@ -321,6 +282,9 @@ func Client_sendTxns(cons *Connections, txnsPath string) (int, error) {
 	return num_added, nil
 }
 
+const Client_BATCH_SIZE = 1000 // txns in one MSG_TXN_BATCH
+
+// sends batches of txns, one batch per connection at a time
 func Client_sendTxnsMT(cons []*Connections, txnsPath string) (int, error) {
 
 	var num_added = 0
@@ -335,17 +299,24 @@ func Client_sendTxnsMT(cons []*Connections, txnsPath string) (int, error) {
 
 		for _, c := range cons {
 
-			bytes := int(binary.LittleEndian.Uint64(data[pos : pos+8]))
-			pos += 8
+			var batch [][]byte
+			for pos < len(data) && len(batch) < Client_BATCH_SIZE {
+				bytes := int(binary.LittleEndian.Uint64(data[pos : pos+8]))
+				pos += 8
+
+				batch = append(batch, data[pos:pos+bytes])
+				pos += bytes
+			}
+			if len(batch) == 0 {
+				break
+			}
 
-			//node.net.txnsPool.Add(data[pos : pos+bytes])
-			err := c.SendTxn(data[pos : pos+bytes])
+			_, err := c.SendTxnBatch(batch, false)
 			if err != nil {
 				log.Printf("Client_sendTxns() failed: %v\n", err)
 			}
-			pos += bytes
 
-			num_added++
+			num_added += len(batch)
 		}
 
 	}
@@ -367,7 +338,7 @@ func Client_sendBlocks(cons *Connections, blocksPath string) (int, error) {
 		pos += 8
 
 		//node.net.blocksPool.Add(data[pos : pos+bytes])
-		err := cons.SendBlock(data[pos : pos+bytes])
+		err := cons.SendBlock(data[pos:pos+bytes], false)
 		if err != nil {
 			fmt.Printf("Client_sendTxns() failed: %v\n", err)
 		}
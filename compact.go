@@ -0,0 +1,284 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"time"
+)
+
+const Cmpct_MAX = 16 // blocks waiting for missing txns
+const Cmpct_TIMEOUT = 30 * time.Second
+
+// compact block which is being rebuilt from txns pool
+type CmpctBlock struct {
+	hash     [32]byte
+	aggSigns []byte
+	msgs     [][]byte // nil = missing
+	peer     *Peer
+	time     time.Time
+}
+
+// SipHash-2-4 keys from block hash and sender's nonce(BIP-152), so short ids collisions can't be prepared for all blocks
+type CmpctKey struct {
+	k0 uint64
+	k1 uint64
+}
+
+func NewCmpctKey(hash []byte, nonce [8]byte) *CmpctKey {
+	h := sha256.Sum256(append(append([]byte(nil), hash...), nonce[:]...))
+	return &CmpctKey{k0: binary.LittleEndian.Uint64(h[0:]), k1: binary.LittleEndian.Uint64(h[8:])}
+}
+
+func _Cmpct_sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13) ^ v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16) ^ v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21) ^ v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17) ^ v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
+
+// SipHash-2-4 of txn id
+func (key *CmpctKey) ShortId(id []byte) uint64 {
+	v0 := key.k0 ^ 0x736f6d6570736575
+	v1 := key.k1 ^ 0x646f72616e646f6d
+	v2 := key.k0 ^ 0x6c7967656e657261
+	v3 := key.k1 ^ 0x7465646279746573
+
+	n := len(id)
+	for ; len(id) >= 8; id = id[8:] {
+		m := binary.LittleEndian.Uint64(id)
+		v3 ^= m
+		v0, v1, v2, v3 = _Cmpct_sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = _Cmpct_sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+	m := uint64(n) << 56
+	for i := range id {
+		m |= uint64(id[i]) << (8 * i)
+	}
+	v3 ^= m
+	v0, v1, v2, v3 = _Cmpct_sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = _Cmpct_sipRound(v0, v1, v2, v3)
+	v0 ^= m
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = _Cmpct_sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// returns MSG_CMPCTBLOCK for block data, short ids are salted by random nonce
+func Cmpct_Build(hash []byte, data []byte) ([]byte, error) {
+
+	_, msgs, err := BlockRaw_ReadTxns(data)
+	if err != nil {
+		return nil, fmt.Errorf("Cmpct_Build() failed: %w", err)
+	}
+
+	var nonce [8]byte
+	_, err = rand.Read(nonce[:])
+	if err != nil {
+		return nil, fmt.Errorf("Cmpct_Build() nonce failed: %w", err)
+	}
+	key := NewCmpctKey(hash, nonce)
+
+	shorts := make([]byte, len(msgs)*8)
+	for i, msg := range msgs {
+		id, err := TxnRaw_Hash(msg)
+		if err != nil {
+			return nil, fmt.Errorf("Cmpct_Build() failed: %w", err)
+		}
+		binary.LittleEndian.PutUint64(shorts[i*8:], key.ShortId(id))
+	}
+
+	return NetCmpctBlock_Serialize(hash, nonce, data[:NetCmpct_SignsSize()], shorts), nil
+}
+
+func (net *Server) _getBlockData(hash [32]byte) ([]byte, bool) {
+	data, found := net.relayBlocks.Get(hash)
+	if !found {
+		data, found = net.node.GetBlockByHash(hash[:])
+	}
+	return data, found
+}
+
+// answers GETDATA(INV_CMPCTBLOCK)
+func (net *Server) _sendCmpct(peer *Peer, hash [32]byte) error {
+
+	msg, found := net.relayCmpcts.Get(hash)
+	if !found {
+		data, found := net._getBlockData(hash)
+		if !found {
+			return nil
+		}
+
+		var err error
+		msg, err = Cmpct_Build(hash[:], data)
+		if err != nil {
+			log.Printf("Error: _sendCmpct() failed: %v\n", err)
+			return nil
+		}
+		net.relayCmpcts.Add(hash, msg)
+	}
+
+	return peer.Write(msg)
+}
+
+func (net *Server) _onGetBlockTxn(peer *Peer, payload []byte) error {
+
+	hash, indexes, err := NetGetBlockTxn_Deserialize(payload)
+	if err != nil {
+		return err
+	}
+
+	data, found := net._getBlockData(hash)
+	if !found {
+		return nil
+	}
+	_, msgs, err := BlockRaw_ReadTxns(data)
+	if err != nil {
+		log.Printf("Error: _onGetBlockTxn() failed: %v\n", err)
+		return nil
+	}
+
+	out := make([][]byte, len(indexes))
+	for i, index := range indexes {
+		if index < 0 || index >= len(msgs) {
+			return errors.New("_onGetBlockTxn() index is out of range")
+		}
+		out[i] = msgs[index]
+	}
+	return peer.Write(NetBlockTxn_Serialize(hash, indexes, out))
+}
+
+func (net *Server) _onCmpctBlock(peer *Peer, payload []byte) error {
+
+	hash, nonce, aggSigns, shorts, err := NetCmpctBlock_Deserialize(payload)
+	if err != nil {
+		return err
+	}
+	peer.known.Add(hash, nil)
+	if net.seen.Has(hash) {
+		return nil
+	}
+
+	cb := &CmpctBlock{hash: hash, aggSigns: aggSigns, msgs: make([][]byte, len(shorts)/8), peer: peer, time: time.Now()}
+
+	var missing []int
+	items := net.txnsPool.FindShorts(NewCmpctKey(hash[:], nonce), shorts)
+	for i := range cb.msgs {
+		if items[i] != nil {
+			cb.msgs[i] = PoolTxns_Msg(items[i])
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	if len(missing) == 0 {
+		return net._finishCmpct(cb)
+	}
+
+	net.cmpct_lock.Lock()
+	now := time.Now()
+	for h, it := range net.cmpcts {
+		if len(net.cmpcts) >= Cmpct_MAX || now.Sub(it.time) > Cmpct_TIMEOUT {
+			delete(net.cmpcts, h)
+		}
+	}
+	net.cmpcts[hash] = cb
+	net.cmpct_lock.Unlock()
+
+	return peer.Write(NetGetBlockTxn_Serialize(hash, missing))
+}
+
+func (net *Server) _onBlockTxn(peer *Peer, payload []byte) error {
+
+	hash, indexes, msgs, err := NetBlockTxn_Deserialize(payload)
+	if err != nil {
+		return err
+	}
+
+	net.cmpct_lock.Lock()
+	cb, found := net.cmpcts[hash]
+	if found && cb.peer == peer {
+		delete(net.cmpcts, hash)
+	}
+	net.cmpct_lock.Unlock()
+
+	if !found || cb.peer != peer {
+		return nil // not requested or timeouted
+	}
+
+	for i, index := range indexes {
+		if index < 0 || index >= len(cb.msgs) || cb.msgs[index] != nil {
+			return errors.New("_onBlockTxn() wrong txn index")
+		}
+		cb.msgs[index] = msgs[i]
+	}
+
+	return net._finishCmpct(cb)
+}
+
+// builds full block, if it doesn't match hash(short id collision or missing txns), full block is downloaded
+func (net *Server) _finishCmpct(cb *CmpctBlock) error {
+
+	data := append([]byte(nil), cb.aggSigns...)
+	for _, msg := range cb.msgs {
+		if msg == nil {
+			return net._getFullBlock(cb)
+		}
+		data = append(data, msg...)
+	}
+
+	hash, err := TBuffer_sha256(data)
+	if err != nil {
+		return fmt.Errorf("_finishCmpct() failed: %w", err)
+	}
+	if [32]byte(hash) != cb.hash {
+		return net._getFullBlock(cb)
+	}
+
+	_, code, err := net.AddBlock(data)
+	if err != nil {
+		log.Printf("Error: AddBlock() failed: %v", err)
+	}
+	if code == ACK_OK {
+		net.blockSources.Add(cb.hash, []byte(cb.peer.ban_key))
+	}
+	return nil
+}
+
+func (net *Server) _getFullBlock(cb *CmpctBlock) error {
+	var item [NetInv_ITEM_SIZE]byte
+	item[0] = INV_BLOCK
+	copy(item[1:], cb.hash[:])
+	return cb.peer.Write(NetInv_Serialize(MSG_GETDATA, item[:]))
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// test vectors from SipHash paper(key 00..0f, message 00..len-1)
+func TestCmpctSipHash(t *testing.T) {
+	key := CmpctKey{k0: 0x0706050403020100, k1: 0x0f0e0d0c0b0a0908}
+
+	tests := []struct {
+		len  int
+		hash uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{8, 0x93f5f5799a932462},
+		{15, 0xa129ca6149be45e5},
+		{32, 0x7127512f72f27cce}, // txn id size
+	}
+	for _, tt := range tests {
+		msg := make([]byte, tt.len)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		if h := key.ShortId(msg); h != tt.hash {
+			t.Errorf("len %d: %x, expected %x", tt.len, h, tt.hash)
+		}
+	}
+}
+
+func TestCmpctShortIdSalt(t *testing.T) {
+	id := make([]byte, 32)
+	hash := make([]byte, 32)
+
+	a := NewCmpctKey(hash, [8]byte{1}).ShortId(id)
+	b := NewCmpctKey(hash, [8]byte{2}).ShortId(id)
+	hash[0] = 1
+	c := NewCmpctKey(hash, [8]byte{1}).ShortId(id)
+	if a == b || a == c {
+		t.Error("short id doesn't depend on nonce or block hash")
+	}
+	if a == binary.LittleEndian.Uint64(id) {
+		t.Error("short id isn't salted")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type NodeConfigTLS struct {
+	Cert   string   `json:"cert"`
+	Key    string   `json:"key"`
+	Ca     string   `json:"ca"`
+	Pins   []string `json:"pins"`
+	Mutual bool     `json:"mutual"`
+}
+
+// nil = default
+type NodeConfigLimits struct {
+	MaxConnections *int     `json:"maxConnections"`
+	TxnsPerSecConn *float64 `json:"txnsPerSecConn"` // 0 = unlimited
+	TxnsPerSecIp   *float64 `json:"txnsPerSecIp"`
+}
+
+// Node settings from json file. Missing values keep defaults
+type NodeConfig struct {
+	Network string          `json:"network"` // mainnet, testnet, regtest
+	Params  json.RawMessage `json:"params"`  // overrides parameters of network, e.g. {"maxBlockBytes": 65536}
+
+	DataDir     string   `json:"dataDir"`
+	Port        int      `json:"port"`
+	Peers       []string `json:"peers"`
+	Seeds       []string `json:"seeds"`
+	TxnsInBlock int      `json:"txnsInBlock"`
+	BlocksPath  string   `json:"blocksPath"`
+
+	Tls    *NodeConfigTLS    `json:"tls"`
+	Limits *NodeConfigLimits `json:"limits"`
+}
+
+func NewNodeConfig(path string) (*NodeConfig, error) {
+	var self NodeConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("NewNodeConfig() ReadFile() failed: %w", err)
+	}
+	err = json.Unmarshal(data, &self)
+	if err != nil {
+		return nil, fmt.Errorf("NewNodeConfig() Unmarshal() failed: %w", err)
+	}
+	return &self, nil
+}
+
+// parameter set with overrides from config. Changed parameters have different hash, so it's new network
+func (cfg *NodeConfig) NetParams(network string) (NetParams, error) {
+	p, err := NetParams_Get(network)
+	if err != nil {
+		return p, err
+	}
+	if len(cfg.Params) > 0 {
+		base := p
+		err = json.Unmarshal(cfg.Params, &p)
+		if err != nil {
+			return p, fmt.Errorf("NodeConfig.NetParams() Unmarshal() failed: %w", err)
+		}
+		// identity of network is chosen by -network
+		if p.Name != base.Name || p.NetworkId != base.NetworkId || p.Regtest != base.Regtest {
+			return p, fmt.Errorf("NodeConfig.NetParams() 'params' can't change name, networkId or regtest, use -network")
+		}
+	}
+	return p, p.Check()
+}
+
+func (cfg *NodeConfig) NetLimits() NetLimits {
+	limits := NetLimits_Default()
+	if cfg.Limits != nil {
+		if cfg.Limits.MaxConnections != nil {
+			limits.max_connections = *cfg.Limits.MaxConnections
+		}
+		if cfg.Limits.TxnsPerSecConn != nil {
+			limits.txns_per_sec_conn = *cfg.Limits.TxnsPerSecConn
+		}
+		if cfg.Limits.TxnsPerSecIp != nil {
+			limits.txns_per_sec_ip = *cfg.Limits.TxnsPerSecIp
+		}
+	}
+	return limits
+}
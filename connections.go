@@ -17,94 +17,510 @@ limitations under the License.
 package main
 
 import (
-	"crypto/tls"
 	"errors"
 	"fmt"
-	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const Connections_ACK_TIMEOUT = 10 * time.Second
+const Connections_RECONNECT_MIN = 100 * time.Millisecond
+const Connections_RECONNECT_MAX = 30 * time.Second
+
+// Send modes
+const (
+	Connections_BROADCAST   = 0 // all healthy endpoints
+	Connections_ANY         = 1 // first healthy endpoint
+	Connections_ROUND_ROBIN = 2 // next healthy endpoint
+)
+
+// One node which client is connected to. Broken link is reconnected in background
+type ConnectionsEndpoint struct {
+	addr    string // host:port
+	path    string
+	tlsConf *NetTLS
+
+	lock       sync.Mutex
+	conn       *websocket.Conn // nil = disconnected
+	fails      int             // reconnects in row which failed
+	last_error error
+	last_ok    time.Time // connected or sent
+}
+
+type ConnectionsHealth struct {
+	Addr      string `json:"addr"`
+	Healthy   bool   `json:"healthy"`
+	Fails     int    `json:"fails"`
+	LastError string `json:"lastError"`
+}
+
+// ack with endpoint which sent it
+type ConnectionsAck struct {
+	ep  *ConnectionsEndpoint
+	ack NetAck
+}
+
+// errors of endpoints which didn't ack request
+type ConnectionsError struct {
+	errs map[string]error // key is endpoint addr
+}
+
+func (e *ConnectionsError) Add(addr string, err error) {
+	if e.errs == nil {
+		e.errs = make(map[string]error)
+	}
+	e.errs[addr] = err
+}
+
+// error of one endpoint, nil if it's ok
+func (e *ConnectionsError) Get(addr string) error {
+	return e.errs[addr]
+}
+
+func (e *ConnectionsError) Error() string {
+	var parts []string
+	for addr, err := range e.errs {
+		parts = append(parts, addr+": "+err.Error())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// nil when no endpoint failed
+func (e *ConnectionsError) Result() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return e
+}
+
 type Connections struct {
-	clients []*websocket.Conn
+	hello *NetHello
+	mode  int
+
+	endpoints []*ConnectionsEndpoint
+	rr        atomic.Uint64
+
+	last_req_id atomic.Uint64
+
+	lock  sync.Mutex
+	waits map[uint64]chan ConnectionsAck
+
+	state_lock    sync.Mutex
+	state_changed *sync.Cond
+	inflight      int // sends in progress
+	closed        bool
 }
 
-func NewConnections() *Connections {
+func NewConnections(hello *NetHello, mode int) *Connections {
 	var self Connections
+
+	// clients don't take part in node-to-node relay
+	h := *hello
+	h.msgs &^= Net_RELAY_MSGS
+	self.hello = &h
+	self.mode = mode
+	self.waits = make(map[uint64]chan ConnectionsAck)
+	self.state_changed = sync.NewCond(&self.state_lock)
 	return &self
 }
 
+// waits for in-flight sends, then closes links
 func (cons *Connections) Destroy() {
-	for _, c := range cons.clients {
-		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-		c.Close()
+	cons.state_lock.Lock()
+	cons.closed = true
+	for cons.inflight > 0 {
+		cons.state_changed.Wait()
+	}
+	cons.state_lock.Unlock()
+
+	for _, ep := range cons.endpoints {
+		ep.lock.Lock()
+		if ep.conn != nil {
+			ep.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			ep.conn.Close()
+			ep.conn = nil
+		}
+		ep.lock.Unlock()
 	}
 }
 
-func (cons *Connections) Add(addr string, port int, path string, ssl_on bool) error {
+func (cons *Connections) _isClosed() bool {
+	cons.state_lock.Lock()
+	defer cons.state_lock.Unlock()
+	return cons.closed
+}
 
-	var ssl_proto string
-	if ssl_on {
-		ssl_proto = "wss"
-	} else {
-		ssl_proto = "ws"
+// registers send in progress, Destroy() waits for it
+func (cons *Connections) _begin() error {
+	cons.state_lock.Lock()
+	defer cons.state_lock.Unlock()
+	if cons.closed {
+		return errors.New("Send() connections are closed")
+	}
+	cons.inflight++
+	return nil
+}
+
+func (cons *Connections) _end() {
+	cons.state_lock.Lock()
+	cons.inflight--
+	if cons.inflight == 0 {
+		cons.state_changed.Broadcast()
 	}
+	cons.state_lock.Unlock()
+}
 
-	u := url.URL{Scheme: ssl_proto, Host: addr + ":" + strconv.Itoa(port), Path: "/" + path} //wss = for SSL
+// first connection must succeed, later disconnections are reconnected
+// tlsConf can be nil(without TLS)
+func (cons *Connections) Add(addr string, port int, path string, tlsConf *NetTLS) error {
 
-	var c *websocket.Conn
-	var err error
-	if ssl_on {
-		d := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-		c, _, err = d.Dial(u.String(), nil)
-	} else {
-		c, _, err = websocket.DefaultDialer.Dial(u.String(), nil) //without SSL
+	ep := &ConnectionsEndpoint{addr: addr + ":" + strconv.Itoa(port), path: path, tlsConf: tlsConf}
+
+	c, err := cons._dial(ep)
+	if err != nil {
+		return fmt.Errorf("NewClient(): %w", err)
+	}
+	ep.conn = c
+	ep.last_ok = time.Now()
+
+	cons.endpoints = append(cons.endpoints, ep)
+	go cons._readLoop(ep, c)
+
+	fmt.Printf("Client connected to %s\n", ep.addr)
+	return nil
+}
+
+func (cons *Connections) _dial(ep *ConnectionsEndpoint) (*websocket.Conn, error) {
+
+	c, err := Net_Dial(ep.addr, ep.path, ep.tlsConf)
+	if err != nil {
+		return nil, err
 	}
 
+	_, err = Net_ClientHandshake(c, cons.hello, cons.last_req_id.Add(1), nil)
 	if err != nil {
-		return fmt.Errorf("NewClient(): Failed to connect to %s with error: %w", u.String(), err)
+		c.Close()
+		return nil, fmt.Errorf("Handshake with %s failed: %w", ep.addr, err)
+	}
+	return c, nil
+}
+
+// marks endpoint as broken and starts reconnecting
+func (cons *Connections) _broken(ep *ConnectionsEndpoint, c *websocket.Conn, err error) {
+
+	ep.lock.Lock()
+	if ep.conn != c {
+		ep.lock.Unlock()
+		return // already handled
+	}
+	ep.conn = nil
+	ep.last_error = err
+	ep.lock.Unlock()
+
+	c.Close()
+	if !cons._isClosed() {
+		go cons._reconnect(ep)
+	}
+}
+
+func (cons *Connections) _reconnect(ep *ConnectionsEndpoint) {
+
+	wait := Connections_RECONNECT_MIN
+	for !cons._isClosed() {
+		time.Sleep(wait)
+
+		c, err := cons._dial(ep)
+		if err != nil {
+			ep.lock.Lock()
+			ep.fails++
+			ep.last_error = err
+			ep.lock.Unlock()
+
+			wait = time.Duration(OsMin64(int64(wait)*2, int64(Connections_RECONNECT_MAX)))
+			continue
+		}
+
+		ep.lock.Lock()
+		ep.conn = c
+		ep.fails = 0
+		ep.last_error = nil
+		ep.last_ok = time.Now()
+		ep.lock.Unlock()
+
+		go cons._readLoop(ep, c)
+		fmt.Printf("Client reconnected to %s\n", ep.addr)
+		return
+	}
+}
+
+func (cons *Connections) Health() []ConnectionsHealth {
+	var ret []ConnectionsHealth
+	for _, ep := range cons.endpoints {
+		ep.lock.Lock()
+		h := ConnectionsHealth{Addr: ep.addr, Healthy: ep.conn != nil, Fails: ep.fails}
+		if ep.last_error != nil {
+			h.LastError = ep.last_error.Error()
+		}
+		ep.lock.Unlock()
+		ret = append(ret, h)
+	}
+	return ret
+}
+
+func (cons *Connections) NumHealthy() int {
+	n := 0
+	for _, h := range cons.Health() {
+		if h.Healthy {
+			n++
+		}
+	}
+	return n
+}
+
+// reads acks from server and passes them to waiting senders. Acks which nobody waits for are dropped
+func (cons *Connections) _readLoop(ep *ConnectionsEndpoint, c *websocket.Conn) {
+	for {
+		mt, message, err := c.ReadMessage()
+		if err != nil || mt == websocket.CloseMessage {
+			if err == nil {
+				err = errors.New("closed by server")
+			}
+			cons._broken(ep, c, err)
+			return
+		}
+		if mt != websocket.BinaryMessage || len(message) == 0 || message[0] != MSG_ACK {
+			continue
+		}
+
+		var ack NetAck
+		err = ack.Deserialize(message)
+		if err != nil {
+			continue
+		}
+
+		cons.lock.Lock()
+		ch, ok := cons.waits[ack.req_id]
+		cons.lock.Unlock()
+		if ok {
+			select {
+			case ch <- ConnectionsAck{ep: ep, ack: ack}:
+			default:
+			}
+		}
 	}
+}
 
-	cons.clients = append(cons.clients, c)
+// writes into one endpoint, error marks it as broken
+func (cons *Connections) _write(ep *ConnectionsEndpoint, msg []byte) error {
 
-	fmt.Printf("Client connected to %s\n", u.String())
+	ep.lock.Lock()
+	c := ep.conn
+	if c == nil {
+		ep.lock.Unlock()
+		return fmt.Errorf("%s is disconnected", ep.addr)
+	}
+	err := c.WriteMessage(websocket.BinaryMessage, msg)
+	if err == nil {
+		ep.last_ok = time.Now()
+	}
+	ep.lock.Unlock()
+
+	if err != nil {
+		cons._broken(ep, c, err)
+		return fmt.Errorf("%s WriteMessage() failed: %w", ep.addr, err)
+	}
 	return nil
 }
 
+// sends message by mode, returns endpoints which got it. Broadcast also returns errors of endpoints which didn't get it
+func (cons *Connections) _send(msg []byte) ([]*ConnectionsEndpoint, *ConnectionsError) {
+
+	var errs ConnectionsError
+	if len(cons.endpoints) == 0 {
+		errs.Add("", errors.New("Send() no endpoints"))
+		return nil, &errs
+	}
+
+	var sent []*ConnectionsEndpoint
+	if cons.mode == Connections_BROADCAST {
+		for _, ep := range cons.endpoints {
+			err := cons._write(ep, msg)
+			if err != nil {
+				errs.Add(ep.addr, err)
+				continue
+			}
+			sent = append(sent, ep)
+		}
+	} else {
+		start := 0
+		if cons.mode == Connections_ROUND_ROBIN {
+			start = int(cons.rr.Add(1) % uint64(len(cons.endpoints)))
+		}
+		for i := 0; i < len(cons.endpoints) && len(sent) == 0; i++ {
+			ep := cons.endpoints[(start+i)%len(cons.endpoints)]
+			err := cons._write(ep, msg)
+			if err != nil {
+				errs.Add(ep.addr, err)
+				continue
+			}
+			sent = append(sent, ep)
+		}
+		if len(sent) > 0 {
+			errs = ConnectionsError{} // other endpoint took it
+		}
+	}
+	return sent, &errs
+}
+
 func (cons *Connections) Send(msg []byte) error {
+	err := cons._begin()
+	if err != nil {
+		return err
+	}
+	defer cons._end()
+
+	sent, errs := cons._send(msg)
+	if len(sent) == 0 {
+		return fmt.Errorf("Send() no healthy endpoint: %w", errs)
+	}
+	return nil
+}
 
-	for _, c := range cons.clients {
-		err := c.WriteMessage(websocket.BinaryMessage, msg)
+// sends message with new request id. If wait is true, waits for acks from all endpoints which got it and returns first rejection as *NetAckError.
+// Endpoints which failed(broadcast) are ignored when some endpoint accepted it, they are in Health()
+func (cons *Connections) SendRequest(msg_type uint8, payload []byte, wait bool) error {
+
+	acks, err := cons.SendRequestAcks(msg_type, payload, wait)
+	if err != nil && len(acks) == 0 {
+		return err
+	}
+	for _, ack := range acks {
+		err = ack.Error()
 		if err != nil {
-			return fmt.Errorf("SendWrite(): WriteMessage() failed: %w", err)
+			return err
 		}
 	}
 	return nil
 }
 
-func (cons *Connections) SendTxn(txn []byte) error {
+// same as SendRequest(), but returns acks from all endpoints which got request.
+// With wait, acks which were received are returned together with *ConnectionsError of endpoints which failed or didn't ack in time
+func (cons *Connections) SendRequestAcks(msg_type uint8, payload []byte, wait bool) ([]NetAck, error) {
+
+	err := cons._begin()
+	if err != nil {
+		return nil, err
+	}
+	defer cons._end()
+
+	req_id := cons.last_req_id.Add(1)
+	msg := Net_WriteHeader(msg_type, req_id, payload)
+
+	if !wait {
+		sent, errs := cons._send(msg)
+		if len(sent) == 0 {
+			return nil, fmt.Errorf("Send() no healthy endpoint: %w", errs)
+		}
+		return nil, nil
+	}
+
+	ch := make(chan ConnectionsAck, len(cons.endpoints))
+	cons.lock.Lock()
+	cons.waits[req_id] = ch
+	cons.lock.Unlock()
+
+	defer func() {
+		cons.lock.Lock()
+		delete(cons.waits, req_id)
+		cons.lock.Unlock()
+	}()
+
+	sent, errs := cons._send(msg)
+	if len(sent) == 0 {
+		return nil, fmt.Errorf("Send() no healthy endpoint: %w", errs)
+	}
+
+	pending := make(map[*ConnectionsEndpoint]bool)
+	for _, ep := range sent {
+		pending[ep] = true
+	}
+
+	timeout := time.NewTimer(Connections_ACK_TIMEOUT)
+	defer timeout.Stop()
+
+	var acks []NetAck
+	for len(pending) > 0 {
+		select {
+		case it := <-ch:
+			if pending[it.ep] {
+				delete(pending, it.ep)
+				acks = append(acks, it.ack)
+			}
+		case <-timeout.C:
+			for ep := range pending {
+				errs.Add(ep.addr, fmt.Errorf("request(%d) ack timeout", req_id))
+			}
+			pending = nil
+		}
+	}
+	return acks, errs.Result()
+}
+
+func (cons *Connections) SendTxn(txn []byte, wait bool) error {
 
 	if len(txn) == 0 {
 		return errors.New("SendTxn() is empty")
 	}
 
-	var msg []byte
-	msg = append(msg, MSG_TXN)
-	msg = append(msg, txn...)
-
-	return cons.Send(msg)
+	return cons.SendRequest(MSG_TXN, txn, wait)
 }
 
-func (cons *Connections) SendBlock(block []byte) error {
+func (cons *Connections) SendBlock(block []byte, wait bool) error {
 
 	if len(block) == 0 {
 		return errors.New("SendBlock() is empty")
 	}
 
-	var msg []byte
-	msg = append(msg, MSG_BLOCK)
-	msg = append(msg, block...)
+	return cons.SendRequest(MSG_BLOCK, block, wait)
+}
+
+// sends many txns in one frame. If wait is true, returns result code for every txn(first rejection from all clients)
+func (cons *Connections) SendTxnBatch(txns [][]byte, wait bool) ([]uint8, error) {
 
-	return cons.Send(msg)
+	if len(txns) == 0 {
+		return nil, errors.New("SendTxnBatch() is empty")
+	}
+	if len(txns) > NetTxnBatch_MAX {
+		return nil, fmt.Errorf("SendTxnBatch() too many txns(%d), max is %d", len(txns), NetTxnBatch_MAX)
+	}
+
+	acks, err := cons.SendRequestAcks(MSG_TXN_BATCH, NetTxnBatch_Serialize(txns), wait)
+	if (err != nil && len(acks) == 0) || !wait {
+		return nil, err
+	}
+
+	codes := make([]uint8, len(txns))
+	for _, ack := range acks {
+		if len(ack.codes) != len(txns) {
+			// whole batch was rejected
+			err = ack.Error()
+			if err == nil {
+				err = errors.New("SendTxnBatch() wrong number of results")
+			}
+			return nil, err
+		}
+		for i, c := range ack.codes {
+			if codes[i] == ACK_OK {
+				codes[i] = c
+			}
+		}
+	}
+	return codes, nil
 }
@@ -0,0 +1,474 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const Events_QUEUE_MAX = 4096   // events waiting for slow client, then client is disconnected
+const Events_REPLAY_MAX = 10000 // blocks replayed after subscribe with 'fromHeight'
+const Events_SUBS_MAX = 1000    // accounts and txns per client
+
+// Topics
+const (
+	Events_BLOCKS  = "blocks"
+	Events_ACCOUNT = "account"
+	Events_POOL    = "pool"
+	Events_TXN     = "txn"
+)
+
+type EventsRequest struct {
+	Op         string `json:"op"` // subscribe, unsubscribe
+	Topic      string `json:"topic"`
+	Account    *int64 `json:"account"` // id
+	PubKey     string `json:"pubKey"`
+	Address    string `json:"address"`    // or short id
+	Txn        string `json:"txn"`        // id
+	FromHeight *int64 `json:"fromHeight"` // blocks and account, resumes from this height
+}
+
+type EventsBlock struct {
+	Type    string `json:"type"`
+	Height  int64  `json:"height"`
+	Hash    string `json:"hash"`
+	Size    int    `json:"size"`
+	NumTxns int    `json:"numTxns"`
+}
+
+type EventsAccount struct {
+	Type    string `json:"type"`
+	Account int64  `json:"account"`
+	Height  int64  `json:"height"`
+	Txn     string `json:"txn"`
+	SrcId   int64  `json:"srcId"`
+	DstId   int64  `json:"dstId"`
+	Amount  int64  `json:"amount"`
+	Fee     int64  `json:"fee"`
+	Balance int64  `json:"balance"` // when event was sent
+	Nonce   int64  `json:"nonce"`
+}
+
+type EventsPool struct {
+	Type   string `json:"type"`
+	Txn    string `json:"txn"`
+	SrcId  int64  `json:"srcId"`
+	Amount int64  `json:"amount"`
+	Fee    int64  `json:"fee"`
+}
+
+type EventsTxn struct {
+	Type string `json:"type"`
+	TxnStatus
+}
+
+type EventsAnswer struct {
+	Type    string `json:"type"` // subscribed, unsubscribed, error
+	Topic   string `json:"topic,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// One client of /events websocket
+type EventsSub struct {
+	conn *websocket.Conn
+	out  chan []byte
+
+	// protected by Events.lock
+	blocks      bool
+	next_height int64 // next block which is sent
+	pool        bool
+	accounts    map[int64]bool
+	pubKeys     map[BLSPubKey]bool // accounts which don't exist yet
+	txns        map[[32]byte]bool
+	closed      bool
+}
+
+// Pushes new blocks, account changes, pool admissions and txn status changes to subscribed clients
+type Events struct {
+	net *Server
+
+	lock sync.Mutex
+	subs map[*EventsSub]bool
+
+	txnIds chan [32]byte // txn status changes
+}
+
+func NewEvents(net *Server) *Events {
+	var self Events
+	self.net = net
+	self.subs = make(map[*EventsSub]bool)
+	self.txnIds = make(chan [32]byte, Events_QUEUE_MAX)
+	go self._txnLoop()
+	return &self
+}
+
+func (sub *EventsSub) _push(v interface{}) {
+	if sub.closed {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	select {
+	case sub.out <- data:
+	default:
+		sub.closed = true // too slow
+		close(sub.out)
+	}
+}
+
+func (events *Events) _remove(sub *EventsSub) {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	delete(events.subs, sub)
+	if !sub.closed {
+		sub.closed = true
+		close(sub.out)
+	}
+}
+
+// reads requests from client until link is closed
+func (events *Events) Loop(c *websocket.Conn) {
+
+	sub := &EventsSub{conn: c, out: make(chan []byte, Events_QUEUE_MAX)}
+	sub.accounts = make(map[int64]bool)
+	sub.pubKeys = make(map[BLSPubKey]bool)
+	sub.txns = make(map[[32]byte]bool)
+
+	events.lock.Lock()
+	events.subs[sub] = true
+	events.lock.Unlock()
+	defer events._remove(sub)
+
+	go func() {
+		for data := range sub.out {
+			err := c.WriteMessage(websocket.TextMessage, data)
+			if err != nil {
+				break
+			}
+		}
+		c.Close()
+	}()
+
+	for {
+		var req EventsRequest
+		err := c.ReadJSON(&req)
+		if err != nil {
+			return
+		}
+
+		err = events._onRequest(sub, &req)
+
+		events.lock.Lock()
+		if err != nil {
+			sub._push(&EventsAnswer{Type: "error", Topic: req.Topic, Message: err.Error()})
+		} else {
+			sub._push(&EventsAnswer{Type: req.Op + "d", Topic: req.Topic})
+		}
+		events.lock.Unlock()
+	}
+}
+
+func (events *Events) _onRequest(sub *EventsSub, req *EventsRequest) error {
+
+	if req.Op != "subscribe" && req.Op != "unsubscribe" {
+		return fmt.Errorf("unknown op(%s)", req.Op)
+	}
+	on := req.Op == "subscribe"
+
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	switch req.Topic {
+	case Events_BLOCKS:
+		sub.blocks = on
+		if on {
+			return events._replay(sub, req.FromHeight, -1)
+		}
+		return nil
+
+	case Events_POOL:
+		sub.pool = on
+		return nil
+
+	case Events_ACCOUNT:
+		if on && len(sub.accounts)+len(sub.pubKeys) >= Events_SUBS_MAX {
+			return fmt.Errorf("too many subscriptions, max is %d", Events_SUBS_MAX)
+		}
+
+		var addr *Address
+		if len(req.Address) > 0 {
+			var err error
+			addr, err = Address_Parse(req.Address)
+			if err != nil {
+				return err
+			}
+			if addr.IsShort() {
+				req.Account = &addr.id
+			}
+		}
+
+		id := int64(-1)
+		if req.Account != nil {
+			id = *req.Account
+		} else if len(req.PubKey) > 0 || addr != nil {
+			var pubKey BLSPubKey
+			if addr != nil {
+				pubKey = *addr.pubKey
+			} else {
+				data, err := hex.DecodeString(req.PubKey)
+				if err != nil || len(data) != len(BLSPubKey{}.arr) {
+					return fmt.Errorf("invalid pubKey")
+				}
+				copy(pubKey.arr[:], data)
+			}
+
+			ledger := events.net.node.ledger
+			ledger.lock.RLock()
+			i, err := ledger.accounts.Find(&pubKey)
+			ledger.lock.RUnlock()
+			if err != nil {
+				// account doesn't exist yet
+				if on {
+					sub.pubKeys[pubKey] = true
+				} else {
+					delete(sub.pubKeys, pubKey)
+				}
+				return nil
+			}
+			id = int64(i)
+		} else {
+			return fmt.Errorf("needs 'account', 'pubKey' or 'address'")
+		}
+
+		if !on {
+			delete(sub.accounts, id)
+			return nil
+		}
+		sub.accounts[id] = true
+		if req.FromHeight != nil {
+			return events._replay(sub, req.FromHeight, id)
+		}
+		return nil
+
+	case Events_TXN:
+		data, err := hex.DecodeString(req.Txn)
+		if err != nil || len(data) != 32 {
+			return fmt.Errorf("invalid txn id")
+		}
+		if !on {
+			delete(sub.txns, [32]byte(data))
+			return nil
+		}
+		if len(sub.txns) >= Events_SUBS_MAX {
+			return fmt.Errorf("too many subscriptions, max is %d", Events_SUBS_MAX)
+		}
+		sub.txns[[32]byte(data)] = true
+		events._queueTxn([32]byte(data)) // sends current status
+		return nil
+	}
+
+	return fmt.Errorf("unknown topic(%s)", req.Topic)
+}
+
+// sends past blocks(account = -1) or past changes of account, must be called under events.lock
+func (events *Events) _replay(sub *EventsSub, fromHeight *int64, account int64) error {
+
+	ledger := events.net.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	numBlocks, err := ledger.NumBlocks()
+	if err != nil {
+		return fmt.Errorf("replay failed: %w", err)
+	}
+	if account < 0 {
+		sub.next_height = numBlocks // only new blocks
+	}
+	if fromHeight == nil {
+		return nil
+	}
+
+	from := OsMax64(*fromHeight, 0)
+	if numBlocks-from > Events_REPLAY_MAX {
+		return fmt.Errorf("fromHeight is too old, max %d blocks can be replayed", Events_REPLAY_MAX)
+	}
+
+	for h := from; h < numBlocks; h++ {
+		hash, data, err := ledger.GetBlock(h)
+		if err != nil {
+			return fmt.Errorf("replay failed: %w", err)
+		}
+		if account < 0 {
+			sub._push(Events_NewBlock(h, hash, data))
+		} else {
+			events._pushAccounts(sub, h, data, map[int64]bool{account: true})
+		}
+	}
+	return nil
+}
+
+func Events_NewBlock(height int64, hash []byte, data []byte) *EventsBlock {
+	_, msgs, _ := BlockRaw_ReadTxns(data)
+	return &EventsBlock{Type: Events_BLOCKS, Height: height, Hash: hex.EncodeToString(hash), Size: len(data), NumTxns: len(msgs)}
+}
+
+// sends txns from block which changed accounts, must be called under events.lock and ledger.lock
+func (events *Events) _pushAccounts(sub *EventsSub, height int64, data []byte, accounts map[int64]bool) {
+
+	txns, msgs, err := BlockRaw_ReadTxns(data)
+	if err != nil {
+		return
+	}
+
+	ledger := events.net.node.ledger
+	for i := range txns {
+		txn := &txns[i]
+		dst_id := txn.dst_id
+		if txn.dst_type == 0 {
+			id, err := ledger.accounts.Find(&txn.dst_pubKey)
+			if err != nil {
+				continue
+			}
+			dst_id = int64(id)
+		}
+
+		for _, id := range []int64{txn.src_id, dst_id} {
+			if !accounts[id] {
+				continue
+			}
+			acc, err := ledger.accounts.Get(int(id))
+			if err != nil {
+				continue
+			}
+			h, _ := TxnRaw_Hash(msgs[i])
+			sub._push(&EventsAccount{Type: Events_ACCOUNT, Account: id, Height: height, Txn: hex.EncodeToString(h),
+				SrcId: txn.src_id, DstId: dst_id, Amount: txn.amount, Fee: txn.fee, Balance: acc.amount, Nonce: acc.nonce})
+			if txn.src_id == dst_id {
+				break
+			}
+		}
+	}
+}
+
+// called by Node after block was written into ledger
+func (events *Events) OnBlock(height int64, hash []byte, data []byte) {
+
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	if len(events.subs) == 0 {
+		return
+	}
+
+	ledger := events.net.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	block := Events_NewBlock(height, hash, data)
+	for sub := range events.subs {
+		if sub.blocks && height >= sub.next_height {
+			sub._push(block)
+			sub.next_height = height + 1
+		}
+
+		// accounts which were created
+		for pubKey := range sub.pubKeys {
+			id, err := ledger.accounts.Find(&pubKey)
+			if err == nil {
+				sub.accounts[int64(id)] = true
+				delete(sub.pubKeys, pubKey)
+			}
+		}
+		if len(sub.accounts) > 0 {
+			events._pushAccounts(sub, height, data, sub.accounts)
+		}
+
+		// confirmations have changed
+		for id := range sub.txns {
+			events._queueTxn(id)
+		}
+	}
+}
+
+// called after txn was added into pool
+func (events *Events) OnPool(id []byte, message []byte) {
+
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	var ev *EventsPool
+	for sub := range events.subs {
+		if !sub.pool {
+			continue
+		}
+		if ev == nil {
+			var txn TxnRaw
+			_, _, _, err := txn.InitTxnFromBuffer(NewTBuffer(message), true, false)
+			if err != nil {
+				return
+			}
+			ev = &EventsPool{Type: Events_POOL, Txn: hex.EncodeToString(id), SrcId: txn.src_id, Amount: txn.amount, Fee: txn.fee}
+		}
+		sub._push(ev)
+	}
+}
+
+// called by TxnTracker(under its lock) after status has changed
+func (events *Events) OnTxnStatus(id [32]byte) {
+
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	for sub := range events.subs {
+		if sub.txns[id] {
+			events._queueTxn(id)
+			return
+		}
+	}
+}
+
+func (events *Events) _queueTxn(id [32]byte) {
+	select {
+	case events.txnIds <- id:
+	default:
+		log.Printf("Events: txn status queue is full\n")
+	}
+}
+
+// status is read outside of TxnTracker's lock
+func (events *Events) _txnLoop() {
+	for id := range events.txnIds {
+		st := events.net.TxnStatus(id[:])
+
+		events.lock.Lock()
+		for sub := range events.subs {
+			if sub.txns[id] {
+				sub._push(&EventsTxn{Type: Events_TXN, TxnStatus: st})
+			}
+		}
+		events.lock.Unlock()
+	}
+}
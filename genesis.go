@@ -0,0 +1,143 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const Genesis_DIFFICULTY = 20
+
+type GenesisAlloc struct {
+	PubKey string `json:"pubKey"` // hex
+	Amount int64  `json:"amount"`
+}
+
+// Definition of chain. Accounts are created in order of 'alloc', so first allocation has id 0
+type Genesis struct {
+	ChainId    string         `json:"chainId"`
+	Timestamp  int64          `json:"timestamp"`  // unix
+	Difficulty uint32         `json:"difficulty"` // initial bits for miner
+	Params     NetParams      `json:"params"`
+	Alloc      []GenesisAlloc `json:"alloc"`
+}
+
+// genesis for active network parameters
+func NewGenesis(timestamp int64, difficulty uint32, alloc []GenesisAlloc) *Genesis {
+	var self Genesis
+	self.ChainId = NetParams_Active.NetworkId
+	self.Timestamp = timestamp
+	self.Difficulty = difficulty
+	self.Params = NetParams_Active
+	self.Alloc = alloc
+	return &self
+}
+
+func Genesis_Load(path string) (*Genesis, error) {
+	var self Genesis
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Genesis_Load() ReadFile() failed: %w", err)
+	}
+	err = json.Unmarshal(data, &self)
+	if err != nil {
+		return nil, fmt.Errorf("Genesis_Load() Unmarshal() failed: %w", err)
+	}
+	err = self.Check()
+	if err != nil {
+		return nil, fmt.Errorf("Genesis_Load() %s: %w", path, err)
+	}
+	return &self, nil
+}
+
+func (g *Genesis) Save(path string) error {
+	data, err := json.MarshalIndent(g, "", "\t")
+	if err != nil {
+		return fmt.Errorf("Genesis.Save() Marshal() failed: %w", err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Genesis.Save() WriteFile() failed: %w", err)
+	}
+	return nil
+}
+
+func (g *Genesis) Check() error {
+	if g.ChainId != NetParams_Active.NetworkId {
+		return fmt.Errorf("chain id(%s) is different from network(%s)", g.ChainId, NetParams_Active.NetworkId)
+	}
+	if g.Params.Hash() != NetParams_Active.Hash() {
+		return errors.New("genesis was created for different network parameters")
+	}
+	if len(g.Alloc) == 0 {
+		return errors.New("no allocations")
+	}
+
+	seen := make(map[[48]byte]bool)
+	for i := range g.Alloc {
+		pubKey, err := g.PubKey(i)
+		if err != nil {
+			return err
+		}
+		if seen[pubKey.arr] {
+			return fmt.Errorf("allocation(%d) has duplicated pubKey", i)
+		}
+		seen[pubKey.arr] = true
+
+		if g.Alloc[i].Amount <= 0 {
+			return fmt.Errorf("allocation(%d) has invalid amount(%d)", i, g.Alloc[i].Amount)
+		}
+	}
+	return nil
+}
+
+func (g *Genesis) PubKey(i int) (*BLSPubKey, error) {
+	data, err := hex.DecodeString(g.Alloc[i].PubKey)
+	if err != nil || len(data) != len(BLSPubKey{}.arr) {
+		return nil, fmt.Errorf("allocation(%d) has invalid pubKey", i)
+	}
+	return &BLSPubKey{arr: [48]byte(data)}, nil
+}
+
+// hash of whole definition, nodes with different genesis don't talk together
+func (g *Genesis) Hash() []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(int64(len(g.ChainId)))
+	buff.WriteSBlob([]byte(g.ChainId))
+	buff.WriteNumber(g.Timestamp)
+	buff.WriteNumber(int64(g.Difficulty))
+	params := g.Params.Hash()
+	buff.WriteSBlob(params[:])
+
+	buff.WriteNumber(int64(len(g.Alloc)))
+	for i := range g.Alloc {
+		pubKey, err := g.PubKey(i)
+		if err != nil {
+			pubKey = &BLSPubKey{} // invalid genesis doesn't pass Check()
+		}
+		buff.WriteSBlob(pubKey.arr[:])
+		buff.WriteNumber(g.Alloc[i].Amount)
+	}
+
+	h, _ := TBuffer_sha256(buff.data[:buff.size])
+	return h
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// EIP-2334 path: m / purpose / coin_type / account / use
+const HDKey_PURPOSE = 12381
+const HDKey_COIN_TYPE = 4879 // tin, not registered in SLIP-44
+const HDKey_MNEMONIC_BITS = 256
+
+// order of BLS12-381 group
+var HDKey_R, _ = new(big.Int).SetString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+
+func _HDKey_extract(salt, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+func _HDKey_expand(prk, info []byte, length int) []byte {
+	var okm []byte
+	var t []byte
+	for i := byte(1); len(okm) < length; i++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		okm = append(okm, t...)
+	}
+	return okm[:length]
+}
+
+func _HDKey_modR(ikm []byte) *BLSPrivKey {
+	salt := []byte("BLS-SIG-KEYGEN-SALT-")
+	sk := new(big.Int)
+	for sk.Sign() == 0 {
+		h := sha256.Sum256(salt)
+		salt = h[:]
+		prk := _HDKey_extract(salt, append(append([]byte{}, ikm...), 0))
+		okm := _HDKey_expand(prk, []byte{0, 48}, 48) // key_info="" || I2OSP(L, 2)
+		sk.SetBytes(okm)
+		sk.Mod(sk, HDKey_R)
+	}
+
+	var privKey BLSPrivKey
+	sk.FillBytes(privKey.arr[:]) // big-endian(eth serialization)
+	return &privKey
+}
+
+func _HDKey_lamportPK(parent *BLSPrivKey, index uint32) []byte {
+	var salt [4]byte
+	binary.BigEndian.PutUint32(salt[:], index)
+
+	ikm := parent.arr[:]
+	not_ikm := make([]byte, len(ikm))
+	for i := range ikm {
+		not_ikm[i] = ^ikm[i]
+	}
+
+	var lamport_pk []byte
+	for _, it := range [][]byte{ikm, not_ikm} {
+		okm := _HDKey_expand(_HDKey_extract(salt[:], it), nil, 255*32)
+		for i := 0; i < 255; i++ {
+			h := sha256.Sum256(okm[i*32 : (i+1)*32])
+			lamport_pk = append(lamport_pk, h[:]...)
+		}
+	}
+	h := sha256.Sum256(lamport_pk)
+	return h[:]
+}
+
+// EIP-2333 master key from seed(min 32 bytes)
+func HDKey_DeriveMaster(seed []byte) (*BLSPrivKey, error) {
+	if len(seed) < 32 {
+		return nil, errors.New("HDKey_DeriveMaster() seed must have at least 32 bytes")
+	}
+	return _HDKey_modR(seed), nil
+}
+
+// EIP-2333 child key, all indexes are hardened
+func HDKey_DeriveChild(parent *BLSPrivKey, index uint32) *BLSPrivKey {
+	return _HDKey_modR(_HDKey_lamportPK(parent, index))
+}
+
+// "m/12381/4879/0/0"
+func HDKey_ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) < 2 || parts[0] != "m" {
+		return nil, fmt.Errorf("HDKey_ParsePath() invalid path '%s'", path)
+	}
+
+	var indexes []uint32
+	for _, it := range parts[1:] {
+		index, err := strconv.ParseUint(it, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("HDKey_ParsePath() invalid index '%s' in '%s'", it, path)
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// path of signing key for account
+func HDKey_AccountPath(account uint32) string {
+	return fmt.Sprintf("m/%d/%d/%d/0", HDKey_PURPOSE, HDKey_COIN_TYPE, account)
+}
+
+func HDKey_Derive(seed []byte, path string) (*BLSPrivKey, error) {
+	indexes, err := HDKey_ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := HDKey_DeriveMaster(seed)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		key = HDKey_DeriveChild(key, index)
+	}
+	return key, nil
+}
+
+// new BIP-39 english mnemonic(24 words)
+func HDKey_NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(HDKey_MNEMONIC_BITS)
+	if err != nil {
+		return "", fmt.Errorf("HDKey_NewMnemonic() NewEntropy() failed: %w", err)
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// BIP-39 seed, checksum of mnemonic is verified
+func HDKey_MnemonicSeed(mnemonic string, passphrase string) ([]byte, error) {
+	mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("HDKey_MnemonicSeed() invalid mnemonic: %w", err)
+	}
+	return seed, nil
+}
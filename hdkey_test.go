@@ -0,0 +1,108 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func _Test_keyInt(key *BLSPrivKey) string {
+	return new(big.Int).SetBytes(key.arr[:]).String()
+}
+
+// test vectors from EIP-2333
+func TestHDKeyEIP2333(t *testing.T) {
+	tests := []struct {
+		seed   string
+		master string
+		index  uint32
+		child  string
+	}{
+		{"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+			"6083874454709270928345386274498605044986640685124978867557563392430687146096",
+			0,
+			"20397789859736650942317412262472558107875392172444076792671091975210932703118"},
+		{"3141592653589793238462643383279502884197169399375105820974944592",
+			"29757020647961307431480504535336562678282505419141012933316116377660817309383",
+			3141592653,
+			"25457201688850691947727629385191704516744796114925897962676248250929345014287"},
+		{"0099ff991111002299dd7744ee3355bbdd8844115566cc55663355668888cc00",
+			"27580842291869792442942448775674722299803720648445448686099262467207037398656",
+			4294967295,
+			"29358610794459428860402234341874281240803786294062035874021252734817515685787"},
+		{"d4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3",
+			"19022158461524446591288038168518313374041767046816487870552872741050760015818",
+			42,
+			"31372231650479070279774297061823572166496564838472787488249775572789064611981"},
+	}
+
+	for i, tt := range tests {
+		seed, _ := hex.DecodeString(tt.seed)
+		master, err := HDKey_DeriveMaster(seed)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if _Test_keyInt(master) != tt.master {
+			t.Errorf("%d: master is %s, expected %s", i, _Test_keyInt(master), tt.master)
+		}
+		child := HDKey_DeriveChild(master, tt.index)
+		if _Test_keyInt(child) != tt.child {
+			t.Errorf("%d: child is %s, expected %s", i, _Test_keyInt(child), tt.child)
+		}
+	}
+
+	_, err := HDKey_DeriveMaster(make([]byte, 31))
+	if err == nil {
+		t.Error("short seed was accepted")
+	}
+}
+
+// BIP-39 vector(TREZOR passphrase) gives seed of first EIP-2333 vector
+func TestHDKeyMnemonicSeed(t *testing.T) {
+	seed, err := HDKey_MnemonicSeed("Abandon abandon abandon abandon abandon abandon  abandon abandon abandon abandon abandon about", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(seed) != "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04" {
+		t.Errorf("wrong seed %x", seed)
+	}
+
+	_, err = HDKey_MnemonicSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", "")
+	if err == nil {
+		t.Error("mnemonic with wrong checksum was accepted")
+	}
+}
+
+func TestHDKeyParsePath(t *testing.T) {
+	indexes, err := HDKey_ParsePath(HDKey_AccountPath(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indexes, []uint32{12381, 4879, 5, 0}) {
+		t.Errorf("wrong indexes %v", indexes)
+	}
+
+	for _, path := range []string{"", "m", "12381/0", "m/-1", "m/4294967296", "m/1//2", "m/1'"} {
+		_, err := HDKey_ParsePath(path)
+		if err == nil {
+			t.Errorf("invalid path '%s' was accepted", path)
+		}
+	}
+}
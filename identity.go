@@ -0,0 +1,160 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/herumi/bls-eth-go-binary/bls"
+)
+
+const NodeIdentity_AUTH_DOMAIN = "tin-node-auth"
+
+// Persistent BLS key of node. Peers know node by it, not by IP
+type NodeIdentity struct {
+	privKey BLSPrivKey
+	pubKey  BLSPubKey
+}
+
+// loads key from file(hex), new key is generated and saved when file doesn't exist
+func NewNodeIdentity(path string) (*NodeIdentity, error) {
+	var self NodeIdentity
+
+	if OsFileExists(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("NewNodeIdentity() ReadFile() failed: %w", err)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != len(self.privKey.arr) {
+			return nil, fmt.Errorf("NewNodeIdentity() %s is not a valid key", path)
+		}
+		self.privKey.arr = [32]byte(key)
+	} else {
+		var key bls.SecretKey
+		key.SetByCSPRNG()
+		self.privKey = *NewBLSPrivKey(&key)
+
+		err := os.WriteFile(path, []byte(hex.EncodeToString(self.privKey.arr[:])), 0600)
+		if err != nil {
+			return nil, fmt.Errorf("NewNodeIdentity() WriteFile() failed: %w", err)
+		}
+	}
+
+	err := self.privKey.ExportPublicKey(&self.pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("NewNodeIdentity() failed: %w", err)
+	}
+	return &self, nil
+}
+
+const NodeIdentity_ROLE_CLIENT = 1
+const NodeIdentity_ROLE_SERVER = 2
+
+// message which proves ownership of pubKey. It covers both challenges, both keys and side of signer, so sign can't be relayed into other connection
+func NodeIdentity_AuthMsg(role uint8, client *NetHello, server *NetHello) []byte {
+	data := make([]byte, 0, len(NodeIdentity_AUTH_DOMAIN)+1+2*32+2*48)
+	data = append(data, NodeIdentity_AUTH_DOMAIN...)
+	data = append(data, role)
+	data = append(data, client.challenge[:]...)
+	data = append(data, server.challenge[:]...)
+	data = append(data, client.pubKey.arr[:]...)
+	data = append(data, server.pubKey.arr[:]...)
+
+	h, _ := TBuffer_sha256(data)
+	return h
+}
+
+func (id *NodeIdentity) Sign(msg []byte) (*BLSSign, error) {
+	var key bls.SecretKey
+	err := id.privKey.Export(&key)
+	if err != nil {
+		return nil, fmt.Errorf("Sign() failed: %w", err)
+	}
+	return NewBLSSign(key.SignByte(msg)), nil
+}
+
+func NodeIdentity_Verify(pubKey *BLSPubKey, msg []byte, sign *BLSSign) bool {
+	var pub bls.PublicKey
+	if pubKey.Export(&pub) != nil {
+		return false
+	}
+	var sig bls.Sign
+	if sign.Export(&sig) != nil {
+		return false
+	}
+	return sig.VerifyByte(&pub, msg)
+}
+
+// key for BanList
+func NodeIdentity_BanKey(pubKey *BLSPubKey) string {
+	return "node:" + hex.EncodeToString(pubKey.arr[:])
+}
+
+func NodeIdentity_Challenge() [32]byte {
+	var ch [32]byte
+	rand.Read(ch[:])
+	return ch
+}
+
+// Node keys which can relay and produce blocks. Empty list = open network
+type AllowList struct {
+	keys map[[48]byte]bool
+}
+
+// file is json array of hex pubKeys, missing file = open network
+func NewAllowList(path string) (*AllowList, error) {
+	var self AllowList
+	self.keys = make(map[[48]byte]bool)
+
+	if OsFileExists(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("NewAllowList() ReadFile() failed: %w", err)
+		}
+
+		var items []string
+		err = json.Unmarshal(data, &items)
+		if err != nil {
+			return nil, fmt.Errorf("NewAllowList() Unmarshal() failed: %w", err)
+		}
+		for _, it := range items {
+			key, err := hex.DecodeString(it)
+			if err != nil || len(key) != 48 {
+				return nil, errors.New("NewAllowList() invalid key " + it)
+			}
+			self.keys[[48]byte(key)] = true
+		}
+	}
+
+	return &self, nil
+}
+
+// true = network is permissioned
+func (list *AllowList) IsActive() bool {
+	return len(list.keys) > 0
+}
+
+func (list *AllowList) IsAllowed(pubKey *BLSPubKey) bool {
+	return !list.IsActive() || list.keys[pubKey.arr]
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const Keystore_VERSION = 1
+const Keystore_SCRYPT_N = 1 << 18
+const Keystore_SCRYPT_N_LIGHT = 1 << 12 // regtest, fast unlock in tests
+const Keystore_SCRYPT_R = 8
+const Keystore_SCRYPT_P = 1
+
+// limits of loaded keystore: weak params are refused, huge ones would exhaust memory or CPU
+const Keystore_SCRYPT_N_MAX = 1 << 20
+const Keystore_SCRYPT_R_MAX = 16
+const Keystore_SCRYPT_P_MAX = 16
+const Keystore_SCRYPT_MEM_MAX = 1 << 30 // 128 * N * r
+const Keystore_SALT_MIN = 16
+
+var Keystore_ErrPassword = errors.New("wrong password")
+
+type KeystoreKdf struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"` // hex
+}
+
+type KeystoreCrypto struct {
+	Kdf        string      `json:"kdf"` // scrypt
+	KdfParams  KeystoreKdf `json:"kdfparams"`
+	Cipher     string      `json:"cipher"` // aes-256-gcm
+	Nonce      string      `json:"nonce"`
+	Ciphertext string      `json:"ciphertext"` // with GCM tag, pubKey is additional data
+}
+
+// One password-encrypted private key. PubKey is readable without password
+type KeystoreFile struct {
+	Version int            `json:"version"`
+	Name    string         `json:"name"`
+	PubKey  string         `json:"pubKey"` // hex
+	Crypto  KeystoreCrypto `json:"crypto"`
+}
+
+// light N is accepted only on regtest
+func (kdf *KeystoreKdf) Check() error {
+	n_min := Keystore_SCRYPT_N
+	if NetParams_Active.Regtest {
+		n_min = Keystore_SCRYPT_N_LIGHT
+	}
+	if kdf.N < n_min || kdf.N > Keystore_SCRYPT_N_MAX || kdf.N&(kdf.N-1) != 0 {
+		return fmt.Errorf("scrypt N(%d) must be power of 2 in <%d, %d>", kdf.N, n_min, Keystore_SCRYPT_N_MAX)
+	}
+	if kdf.R < Keystore_SCRYPT_R || kdf.R > Keystore_SCRYPT_R_MAX {
+		return fmt.Errorf("scrypt r(%d) must be in <%d, %d>", kdf.R, Keystore_SCRYPT_R, Keystore_SCRYPT_R_MAX)
+	}
+	if kdf.P < 1 || kdf.P > Keystore_SCRYPT_P_MAX {
+		return fmt.Errorf("scrypt p(%d) must be in <1, %d>", kdf.P, Keystore_SCRYPT_P_MAX)
+	}
+	if 128*int64(kdf.N)*int64(kdf.R) > Keystore_SCRYPT_MEM_MAX {
+		return fmt.Errorf("scrypt N(%d) and r(%d) need too much memory", kdf.N, kdf.R)
+	}
+	return nil
+}
+
+func _Keystore_aead(password []byte, kdf *KeystoreKdf) (cipher.AEAD, error) {
+	err := kdf.Check()
+	if err != nil {
+		return nil, fmt.Errorf("_Keystore_aead() %w", err)
+	}
+	salt, err := hex.DecodeString(kdf.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("_Keystore_aead() invalid salt: %w", err)
+	}
+	if len(salt) < Keystore_SALT_MIN {
+		return nil, fmt.Errorf("_Keystore_aead() salt is shorter than %d bytes", Keystore_SALT_MIN)
+	}
+	key, err := scrypt.Key(password, salt, kdf.N, kdf.R, kdf.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("_Keystore_aead() scrypt failed: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("_Keystore_aead() NewCipher() failed: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func NewKeystoreFile(privKey *BLSPrivKey, password []byte, name string) (*KeystoreFile, error) {
+	var self KeystoreFile
+
+	var pubKey BLSPubKey
+	err := privKey.ExportPublicKey(&pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("NewKeystoreFile() failed: %w", err)
+	}
+
+	var salt [32]byte
+	_, err = rand.Read(salt[:])
+	if err != nil {
+		return nil, fmt.Errorf("NewKeystoreFile() rand failed: %w", err)
+	}
+
+	self.Version = Keystore_VERSION
+	self.Name = name
+	self.PubKey = hex.EncodeToString(pubKey.arr[:])
+	self.Crypto.Kdf = "scrypt"
+	self.Crypto.KdfParams = KeystoreKdf{N: Keystore_SCRYPT_N, R: Keystore_SCRYPT_R, P: Keystore_SCRYPT_P, Salt: hex.EncodeToString(salt[:])}
+	if NetParams_Active.Regtest {
+		self.Crypto.KdfParams.N = Keystore_SCRYPT_N_LIGHT
+	}
+	self.Crypto.Cipher = "aes-256-gcm"
+
+	aead, err := _Keystore_aead(password, &self.Crypto.KdfParams)
+	if err != nil {
+		return nil, fmt.Errorf("NewKeystoreFile() failed: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("NewKeystoreFile() rand failed: %w", err)
+	}
+	self.Crypto.Nonce = hex.EncodeToString(nonce)
+	self.Crypto.Ciphertext = hex.EncodeToString(aead.Seal(nil, nonce, privKey.arr[:], pubKey.arr[:]))
+
+	return &self, nil
+}
+
+func (kf *KeystoreFile) Decrypt(password []byte) (*BLSPrivKey, error) {
+	if kf.Version != Keystore_VERSION || kf.Crypto.Kdf != "scrypt" || kf.Crypto.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("Decrypt() unsupported keystore(version %d, %s, %s)", kf.Version, kf.Crypto.Kdf, kf.Crypto.Cipher)
+	}
+	pubKey, err := kf.GetPubKey()
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt() failed: %w", err)
+	}
+	nonce, err := hex.DecodeString(kf.Crypto.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt() invalid nonce: %w", err)
+	}
+	ciphertext, err := hex.DecodeString(kf.Crypto.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt() invalid ciphertext: %w", err)
+	}
+
+	aead, err := _Keystore_aead(password, &kf.Crypto.KdfParams)
+	if err != nil {
+		return nil, fmt.Errorf("Decrypt() failed: %w", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("Decrypt() invalid nonce size")
+	}
+	plain, err := aead.Open(nil, nonce, ciphertext, pubKey.arr[:])
+	if err != nil {
+		return nil, Keystore_ErrPassword // or file was modified
+	}
+
+	var privKey BLSPrivKey
+	if len(plain) != len(privKey.arr) {
+		return nil, errors.New("Decrypt() wrong key size")
+	}
+	privKey.arr = [32]byte(plain)
+
+	var check BLSPubKey
+	err = privKey.ExportPublicKey(&check)
+	if err != nil || !check.Cmp(pubKey) {
+		return nil, errors.New("Decrypt() key doesn't match pubKey")
+	}
+	return &privKey, nil
+}
+
+func (kf *KeystoreFile) GetPubKey() (*BLSPubKey, error) {
+	data, err := hex.DecodeString(kf.PubKey)
+	if err != nil || len(data) != len(BLSPubKey{}.arr) {
+		return nil, errors.New("invalid pubKey")
+	}
+	return &BLSPubKey{arr: [48]byte(data)}, nil
+}
+
+// Directory with keystore files, one file per key(<pubKey>.json)
+type Keystore struct {
+	dir string
+}
+
+func NewKeystore(dir string) (*Keystore, error) {
+	var self Keystore
+	self.dir = dir
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("NewKeystore() MkdirAll() failed: %w", err)
+	}
+	return &self, nil
+}
+
+// encrypts key and saves it. Returns file path
+func (ks *Keystore) Import(privKey *BLSPrivKey, password []byte, name string) (string, error) {
+	if len(password) == 0 {
+		return "", errors.New("Import() password is empty")
+	}
+	if len(name) > 0 {
+		_, err := ks.Find(name)
+		if err == nil {
+			return "", fmt.Errorf("Import() name '%s' is already used", name)
+		}
+	}
+
+	kf, err := NewKeystoreFile(privKey, password, name)
+	if err != nil {
+		return "", fmt.Errorf("Import() failed: %w", err)
+	}
+
+	path := filepath.Join(ks.dir, kf.PubKey+".json")
+	if OsFileExists(path) {
+		return "", fmt.Errorf("Import() key %s is already in keystore", kf.PubKey)
+	}
+
+	data, err := json.MarshalIndent(kf, "", "\t")
+	if err != nil {
+		return "", fmt.Errorf("Import() Marshal() failed: %w", err)
+	}
+	err = os.WriteFile(path, data, 0600)
+	if err != nil {
+		return "", fmt.Errorf("Import() WriteFile() failed: %w", err)
+	}
+	return path, nil
+}
+
+// sorted by name and pubKey
+func (ks *Keystore) List() ([]*KeystoreFile, error) {
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("List() failed: %w", err)
+	}
+
+	var ret []*KeystoreFile
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("List() ReadFile() failed: %w", err)
+		}
+		var kf KeystoreFile
+		err = json.Unmarshal(data, &kf)
+		if err != nil {
+			return nil, fmt.Errorf("List() %s: %w", path, err)
+		}
+		ret = append(ret, &kf)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].PubKey < ret[j].PubKey
+	})
+	return ret, nil
+}
+
+// id is name or hex pubKey(prefix is enough if it's unique)
+func (ks *Keystore) Find(id string) (*KeystoreFile, error) {
+	list, err := ks.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *KeystoreFile
+	hexId := strings.ToLower(id)
+	for _, kf := range list {
+		if kf.Name == id || kf.PubKey == hexId {
+			return kf, nil
+		}
+		if len(hexId) >= 8 && strings.HasPrefix(kf.PubKey, hexId) {
+			if found != nil {
+				return nil, fmt.Errorf("Find() '%s' matches more keys", id)
+			}
+			found = kf
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Find() key '%s' not found in %s", id, ks.dir)
+	}
+	return found, nil
+}
+
+// returns decrypted private key
+func (ks *Keystore) Export(id string, password []byte) (*BLSPrivKey, error) {
+	kf, err := ks.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	return kf.Decrypt(password)
+}
+
+// returns account which can sign txns
+func (ks *Keystore) Unlock(id string, password []byte) (*ClientAccount, error) {
+	privKey, err := ks.Export(id, password)
+	if err != nil {
+		return nil, fmt.Errorf("Unlock() failed: %w", err)
+	}
+	return NewClientAccount(privKey)
+}
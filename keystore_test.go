@@ -0,0 +1,151 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// switches active network for one test
+func _Test_useParams(t *testing.T, p NetParams) {
+	err := p.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		m := NetParams_Mainnet()
+		m.Apply()
+	})
+}
+
+func _Test_privKey(t *testing.T, hexKey string) *BLSPrivKey {
+	data, err := hex.DecodeString(hexKey)
+	if err != nil || len(data) != 32 {
+		t.Fatalf("invalid test key %s", hexKey)
+	}
+	return &BLSPrivKey{arr: [32]byte(data)}
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	err := InitBLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_Test_useParams(t, NetParams_Regtest()) // light scrypt
+
+	ks, err := NewKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey := _Test_privKey(t, "0d7359d57963ab8fbbde1852dcf553fedbc31f464d80ee7d40ae683122b45070")
+	password := []byte("correct horse")
+
+	_, err = ks.Import(privKey, password, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ks.Import(privKey, password, "alice")
+	if err == nil {
+		t.Fatal("name can't be imported twice")
+	}
+
+	var pubKey BLSPubKey
+	privKey.ExportPublicKey(&pubKey)
+	for _, id := range []string{"alice", hex.EncodeToString(pubKey.arr[:]), hex.EncodeToString(pubKey.arr[:4])} {
+		key, err := ks.Export(id, password)
+		if err != nil {
+			t.Fatalf("Export(%s) failed: %v", id, err)
+		}
+		if key.arr != privKey.arr {
+			t.Fatalf("Export(%s) returned different key", id)
+		}
+	}
+
+	_, err = ks.Export("alice", []byte("wrong horse"))
+	if !errors.Is(err, Keystore_ErrPassword) {
+		t.Fatalf("wrong password returned %v", err)
+	}
+}
+
+func TestKeystoreScryptBounds(t *testing.T) {
+	salt := hex.EncodeToString(make([]byte, 32))
+
+	tests := []struct {
+		regtest bool
+		kdf     KeystoreKdf
+		ok      bool
+	}{
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N, R: 8, P: 1}, true},
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N_LIGHT, R: 8, P: 1}, false}, // light N only on regtest
+		{true, KeystoreKdf{N: Keystore_SCRYPT_N_LIGHT, R: 8, P: 1}, true},
+		{true, KeystoreKdf{N: Keystore_SCRYPT_N_LIGHT / 2, R: 8, P: 1}, false},
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N + 1, R: 8, P: 1}, false}, // not power of 2
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N_MAX * 2, R: 8, P: 1}, false},
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N, R: 1, P: 1}, false},
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N, R: 8, P: 0}, false},
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N, R: 8, P: Keystore_SCRYPT_P_MAX + 1}, false},
+		{false, KeystoreKdf{N: Keystore_SCRYPT_N_MAX, R: Keystore_SCRYPT_R_MAX, P: 1}, false}, // 2GB
+	}
+	for i, tt := range tests {
+		p := NetParams_Mainnet()
+		if tt.regtest {
+			p = NetParams_Regtest()
+		}
+		_Test_useParams(t, p)
+
+		tt.kdf.Salt = salt
+		err := tt.kdf.Check()
+		if (err == nil) != tt.ok {
+			t.Errorf("%d: Check(%+v) returned %v", i, tt.kdf, err)
+		}
+	}
+}
+
+func TestKeystoreWeakFileIsRefused(t *testing.T) {
+	err := InitBLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_Test_useParams(t, NetParams_Regtest())
+
+	ks, err := NewKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey := _Test_privKey(t, "0d7359d57963ab8fbbde1852dcf553fedbc31f464d80ee7d40ae683122b45070")
+	path, err := ks.Import(privKey, []byte("pass"), "weak")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// file with lowered N must not be unlocked
+	data, _ := os.ReadFile(path)
+	var kf KeystoreFile
+	json.Unmarshal(data, &kf)
+	kf.Crypto.KdfParams.N = 2
+	data, _ = json.Marshal(&kf)
+	os.WriteFile(path, data, 0600)
+
+	_, err = ks.Export("weak", []byte("pass"))
+	if err == nil || errors.Is(err, Keystore_ErrPassword) {
+		t.Fatalf("weak scrypt params were accepted: %v", err)
+	}
+}
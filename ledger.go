@@ -18,7 +18,9 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -26,12 +28,22 @@ import (
 type Ledger struct {
 	accounts *Accounts
 
+	lock sync.RWMutex // Node writes, Rpc reads
+
 	dbPath string
 
 	db             *sql.DB
 	insertTxn      *sql.Stmt
 	numRowsTxn     *sql.Stmt
 	selectTxnBlock *sql.Stmt
+
+	insertBlock       *sql.Stmt
+	selectBlock       *sql.Stmt
+	selectBlockByHash *sql.Stmt
+	selectBlockHashes *sql.Stmt
+	numRowsBlock      *sql.Stmt
+	insertTxnId       *sql.Stmt
+	selectTxnId       *sql.Stmt
 }
 
 func NewLedger(dbPath string) (*Ledger, error) {
@@ -44,8 +56,9 @@ func NewLedger(dbPath string) (*Ledger, error) {
 		self.Destroy()
 		return nil, fmt.Errorf("NewDb() Open() failed: %w", err)
 	}
+	self.db.SetMaxOpenConns(1) // BEGIN, statements and COMMIT must use same connection
 
-	_, err = self.db.Exec("CREATE TABLE Txns(account_id INTEGER, amount INTEGER, nonce INTEGER, pre_rowid INTEGER);")
+	_, err = self.db.Exec("CREATE TABLE IF NOT EXISTS Txns(account_id INTEGER, amount INTEGER, nonce INTEGER, pre_rowid INTEGER);")
 	if err != nil {
 		self.Destroy()
 		return nil, fmt.Errorf("NewLedger() Exec1() failed: %w", err)
@@ -80,6 +93,72 @@ func NewLedger(dbPath string) (*Ledger, error) {
 		return nil, fmt.Errorf("NewLedger() numRowsTxn stmt failed: %w", err)
 	}
 
+	_, err = self.db.Exec("CREATE TABLE IF NOT EXISTS Blocks(hash BLOB, data BLOB);")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() Exec3() failed: %w", err)
+	}
+
+	_, err = self.db.Exec("CREATE TABLE IF NOT EXISTS TxnIds(hash BLOB, block_id INTEGER);")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() Exec4() failed: %w", err)
+	}
+
+	_, err = self.db.Exec("CREATE INDEX IF NOT EXISTS TxnIdsIndex_hash on TxnIds (hash);")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() Exec5() failed: %w", err)
+	}
+
+	_, err = self.db.Exec("CREATE INDEX IF NOT EXISTS BlocksIndex_hash on Blocks (hash);")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() Exec6() failed: %w", err)
+	}
+
+	self.insertBlock, err = self.db.Prepare("INSERT INTO Blocks(hash, data) VALUES(?,?);")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() insertBlock stmt failed: %w", err)
+	}
+
+	self.selectBlock, err = self.db.Prepare("SELECT hash, data FROM Blocks WHERE _rowid_ = ?;")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() selectBlock stmt failed: %w", err)
+	}
+
+	self.selectBlockByHash, err = self.db.Prepare("SELECT _rowid_ FROM Blocks WHERE hash = ?;")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() selectBlockByHash stmt failed: %w", err)
+	}
+
+	self.selectBlockHashes, err = self.db.Prepare("SELECT hash FROM Blocks WHERE _rowid_ >= ? ORDER BY _rowid_ LIMIT ?;")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() selectBlockHashes stmt failed: %w", err)
+	}
+
+	self.numRowsBlock, err = self.db.Prepare("SELECT COUNT(*) FROM Blocks;")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() numRowsBlock stmt failed: %w", err)
+	}
+
+	self.insertTxnId, err = self.db.Prepare("INSERT INTO TxnIds(hash, block_id) VALUES(?,?);")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() insertTxnId stmt failed: %w", err)
+	}
+
+	self.selectTxnId, err = self.db.Prepare("SELECT block_id FROM TxnIds WHERE hash = ?;")
+	if err != nil {
+		self.Destroy()
+		return nil, fmt.Errorf("NewLedger() selectTxnId stmt failed: %w", err)
+	}
+
 	self.accounts, err = NewAccounts(self.db)
 	if err != nil {
 		self.Destroy()
@@ -104,28 +183,56 @@ func (ledger *Ledger) Destroy() {
 		ledger.selectTxnBlock.Close()
 	}
 
+	if ledger.insertBlock != nil {
+		ledger.insertBlock.Close()
+	}
+	if ledger.selectBlock != nil {
+		ledger.selectBlock.Close()
+	}
+	if ledger.selectBlockByHash != nil {
+		ledger.selectBlockByHash.Close()
+	}
+	if ledger.selectBlockHashes != nil {
+		ledger.selectBlockHashes.Close()
+	}
+	if ledger.numRowsBlock != nil {
+		ledger.numRowsBlock.Close()
+	}
+	if ledger.insertTxnId != nil {
+		ledger.insertTxnId.Close()
+	}
+	if ledger.selectTxnId != nil {
+		ledger.selectTxnId.Close()
+	}
+
 	if ledger.db != nil {
 		ledger.db.Close()
 	}
 }
 
+// ledger.lock must be held from BatchStart() until BatchCommit() or BatchRollback(), so readers don't see unfinished block
 func (ledger *Ledger) BatchStart() error {
 	_, err := ledger.db.Exec("BEGIN")
 	if err != nil {
 		return fmt.Errorf("BatchStart() failed: %w", err)
 	}
+	ledger.accounts.Begin()
 	return nil
 }
 
+// on error, caller must call BatchRollback()
 func (ledger *Ledger) BatchCommit() error {
 	_, err := ledger.db.Exec("COMMIT")
 	if err != nil {
 		return fmt.Errorf("BatchCommit() failed: %w", err)
 	}
+	ledger.accounts.Commit()
 	return nil
 }
 
+// reverts database and accounts in memory
 func (ledger *Ledger) BatchRollback() error {
+	ledger.accounts.Rollback()
 	_, err := ledger.db.Exec("ROLLBACK")
 	if err != nil {
 		return fmt.Errorf("BatchRollback() failed: %w", err)
@@ -133,6 +240,41 @@ func (ledger *Ledger) BatchRollback() error {
 	return nil
 }
 
+// writes genesis allocations into empty ledger, so they survive restart
+func (ledger *Ledger) AddGenesis(genesis *Genesis) error {
+	if len(ledger.accounts.accounts) > 0 {
+		return nil
+	}
+
+	err := ledger.BatchStart()
+	if err != nil {
+		return fmt.Errorf("AddGenesis() failed: %w", err)
+	}
+	for i := range genesis.Alloc {
+		pubKey, err := genesis.PubKey(i)
+		if err == nil {
+			var ac_id int
+			ac_id, err = ledger.accounts.Add(pubKey)
+			if err == nil {
+				ac := ledger.accounts.accounts[ac_id]
+				ac.amount = genesis.Alloc[i].Amount
+				ac.nonce = 0
+				ac.txn_row, err = ledger.AddTxn(int64(ac_id), ac.amount, ac.nonce, 0)
+			}
+		}
+		if err != nil {
+			ledger.BatchRollback()
+			return fmt.Errorf("AddGenesis() allocation(%d) failed: %w", i, err)
+		}
+	}
+	err = ledger.BatchCommit()
+	if err != nil {
+		ledger.BatchRollback()
+		return fmt.Errorf("AddGenesis() failed: %w", err)
+	}
+	return nil
+}
+
 func (ledger *Ledger) AddTxn(account_id int64, amount int64, nonce int64, last_rowid int64) (int64, error) {
 
 	res, err := ledger.insertTxn.Exec(account_id, amount, nonce, last_rowid)
@@ -195,3 +337,139 @@ func (ledger *Ledger) GetMaxTxnRow() (int64, error) {
 
 	return numRows, nil
 }
+
+// Block height starts from 0, rowid from 1
+func (ledger *Ledger) AddBlock(data []byte, txnHashes []byte) (int64, error) {
+
+	h, err := TBuffer_sha256(data)
+	if err != nil {
+		return -1, fmt.Errorf("AddBlock() sha256 failed: %w", err)
+	}
+
+	res, err := ledger.insertBlock.Exec(h, data)
+	if err != nil {
+		return -1, fmt.Errorf("AddBlock() Exec() failed: %w", err)
+	}
+
+	row, err := res.LastInsertId()
+	if err != nil {
+		return -1, fmt.Errorf("AddBlock() LastInsertId() failed: %w", err)
+	}
+
+	for i := 0; i+32 <= len(txnHashes); i += 32 {
+		_, err = ledger.insertTxnId.Exec(txnHashes[i:i+32], row-1)
+		if err != nil {
+			return -1, fmt.Errorf("AddBlock() insertTxnId.Exec() failed: %w", err)
+		}
+	}
+
+	return row - 1, nil
+}
+
+func (ledger *Ledger) GetBlock(height int64) ([]byte, []byte, error) {
+
+	rows, err := ledger.selectBlock.Query(height + 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetBlock() failed: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, nil, errors.New("GetBlock() block not found")
+	}
+
+	var hash []byte
+	var data []byte
+	err = rows.Scan(&hash, &data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("GetBlock() Scan() failed: %w", err)
+	}
+
+	return hash, data, nil
+}
+
+func (ledger *Ledger) FindBlock(hash []byte) (int64, error) {
+
+	rows, err := ledger.selectBlockByHash.Query(hash)
+	if err != nil {
+		return -1, fmt.Errorf("FindBlock() failed: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return -1, errors.New("FindBlock() block not found")
+	}
+
+	var row int64
+	err = rows.Scan(&row)
+	if err != nil {
+		return -1, fmt.Errorf("FindBlock() Scan() failed: %w", err)
+	}
+
+	return row - 1, nil
+}
+
+// returns hashes of blocks[from, from+count)
+func (ledger *Ledger) GetBlockHashes(from int64, count int64) ([]byte, error) {
+
+	rows, err := ledger.selectBlockHashes.Query(from+1, count)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlockHashes() failed: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []byte
+	for rows.Next() {
+		var hash []byte
+		err = rows.Scan(&hash)
+		if err != nil {
+			return nil, fmt.Errorf("GetBlockHashes() Scan() failed: %w", err)
+		}
+		hashes = append(hashes, hash...)
+	}
+
+	return hashes, nil
+}
+
+func (ledger *Ledger) NumBlocks() (int64, error) {
+
+	rows, err := ledger.numRowsBlock.Query()
+	if err != nil {
+		return -1, fmt.Errorf("NumBlocks() failed: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return -1, errors.New("NumBlocks() rows.Next() failed")
+	}
+
+	var numRows int64
+	err = rows.Scan(&numRows)
+	if err != nil {
+		return -1, fmt.Errorf("NumBlocks() Scan() failed: %w", err)
+	}
+
+	return numRows, nil
+}
+
+// returns height of block which includes txn
+func (ledger *Ledger) FindTxn(hash []byte) (int64, error) {
+
+	rows, err := ledger.selectTxnId.Query(hash)
+	if err != nil {
+		return -1, fmt.Errorf("FindTxn() failed: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return -1, errors.New("FindTxn() txn not found")
+	}
+
+	var height int64
+	err = rows.Scan(&height)
+	if err != nil {
+		return -1, fmt.Errorf("FindTxn() Scan() failed: %w", err)
+	}
+
+	return height, nil
+}
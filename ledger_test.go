@@ -0,0 +1,115 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+)
+
+func _Test_checkAccount(t *testing.T, ledger *Ledger, i int, amount int64, nonce int64) {
+	acc, err := ledger.accounts.Get(i)
+	if err != nil {
+		t.Fatalf("account(%d): %v", i, err)
+	}
+	if acc.amount != amount || acc.nonce != nonce {
+		t.Errorf("account(%d) has amount %d nonce %d, expected %d %d", i, acc.amount, acc.nonce, amount, nonce)
+	}
+}
+
+func TestLedgerRestart(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "db.sqlite")
+
+	var keyA, keyB BLSPubKey
+	keyA.arr[0] = 1
+	keyB.arr[0] = 2
+	genesis := NewGenesis(Node_REGTEST_TIME, Genesis_DIFFICULTY, []GenesisAlloc{
+		{PubKey: hex.EncodeToString(keyA.arr[:]), Amount: 1000},
+		{PubKey: hex.EncodeToString(keyB.arr[:]), Amount: 500},
+	})
+
+	ledger, err := NewLedger(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ledger.AddGenesis(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one block moves 100 from A to B
+	txnHash := make([]byte, 32)
+	txnHash[0] = 7
+	err = ledger.BatchStart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, diff := range []int64{-100, 100} {
+		acc := ledger.accounts.accounts[i]
+		ledger.accounts.Backup(i)
+		acc.amount += diff
+		if diff < 0 {
+			acc.nonce++
+		}
+		acc.txn_row, err = ledger.AddTxn(int64(i), acc.amount, acc.nonce, acc.txn_row)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = ledger.AddBlock([]byte("block"), txnHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ledger.BatchCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.Destroy()
+
+	// reopens twice, second time checks that nothing was duplicated
+	for run := 0; run < 2; run++ {
+		ledger, err = NewLedger(dbPath)
+		if err != nil {
+			t.Fatalf("reopen(%d): %v", run, err)
+		}
+		err = ledger.AddGenesis(genesis)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ledger.accounts.accounts) != 2 {
+			t.Fatalf("reopen(%d): %d accounts", run, len(ledger.accounts.accounts))
+		}
+		_Test_checkAccount(t, ledger, 0, 900, 1)
+		_Test_checkAccount(t, ledger, 1, 600, 0)
+		i, err := ledger.accounts.Find(&keyB)
+		if err != nil || i != 1 {
+			t.Errorf("reopen(%d): Find() returned %d %v", run, i, err)
+		}
+
+		numBlocks, err := ledger.NumBlocks()
+		if err != nil || numBlocks != 1 {
+			t.Errorf("reopen(%d): NumBlocks() returned %d %v", run, numBlocks, err)
+		}
+		height, err := ledger.FindTxn(txnHash)
+		if err != nil || height != 0 {
+			t.Errorf("reopen(%d): FindTxn() returned %d %v", run, height, err)
+		}
+		ledger.Destroy()
+	}
+}
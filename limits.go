@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits for inbound websocket connections. Zero rate = unlimited
+type NetLimits struct {
+	max_txn_frame   int64 // bytes
+	max_block_frame int64
+	max_other_frame int64
+
+	txns_per_sec_conn float64
+	txns_per_sec_ip   float64
+
+	max_connections int
+}
+
+func NetLimits_Default() NetLimits {
+	var limits NetLimits
+	limits.max_txn_frame = 1024
+	limits.max_block_frame = int64(BlocksPool_ITEM) + 64*1024
+	limits.max_other_frame = 1024 * 1024
+	limits.txns_per_sec_conn = 2000
+	limits.txns_per_sec_ip = 5000
+	limits.max_connections = 256
+	return limits
+}
+
+// websocket read limit(frame with header)
+func (limits *NetLimits) MaxFrame() int64 {
+	return Net_HEADER_SIZE + OsMax64(limits.max_txn_frame, OsMax64(limits.max_block_frame, limits.max_other_frame))
+}
+
+func (limits *NetLimits) MaxPayload(msg_type uint8) int64 {
+	switch msg_type {
+	case MSG_TXN:
+		return limits.max_txn_frame
+	case MSG_BLOCK, MSG_CMPCTBLOCK, MSG_BLOCKTXN, MSG_TXN_BATCH:
+		return limits.max_block_frame
+	}
+	return limits.max_other_frame
+}
+
+type TokenBucket struct {
+	lock sync.Mutex
+
+	rate   float64 // tokens per second, 0 = unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64) *TokenBucket {
+	var self TokenBucket
+	self.rate = rate
+	self.burst = rate * 2
+	self.tokens = self.burst
+	self.last = time.Now()
+	return &self
+}
+
+// returns false if there are not enough tokens
+func (bucket *TokenBucket) Take(n float64) bool {
+	if bucket.rate <= 0 {
+		return true
+	}
+
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+
+	now := time.Now()
+	bucket.tokens = OsMinFloat(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+
+	if bucket.tokens < n {
+		return false
+	}
+	bucket.tokens -= n
+	return true
+}
+
+// takes at most n tokens, returns how many were taken
+func (bucket *TokenBucket) TakeUpTo(n int) int {
+	if bucket.rate <= 0 {
+		return n
+	}
+
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+
+	now := time.Now()
+	bucket.tokens = OsMinFloat(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+
+	taken := OsMin(n, int(bucket.tokens))
+	bucket.tokens -= float64(taken)
+	return taken
+}
+
+// true if bucket is full for long time, so it can be removed
+func (bucket *TokenBucket) IsIdle() bool {
+	bucket.lock.Lock()
+	defer bucket.lock.Unlock()
+
+	return time.Since(bucket.last).Seconds()*bucket.rate+bucket.tokens >= bucket.burst
+}
@@ -17,13 +17,22 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const BlocksPool_ITEM = 1024 * 1024
+const Node_REGTEST_TIME = 1700000000 // default genesis timestamp of regtest
+const Node_REGTEST_BLOCK_TIME = 600
+
+var BlocksPool_ITEM = 1024 * 1024 // max block bytes, set by NetParams.Apply()
 
 type NodeStat struct {
 	start_time float64
@@ -64,7 +73,7 @@ func (stat *NodeStat) Print(ledger *Ledger) {
 	fmt.Printf("Num blocks: %d\n", stat.num_blocks)
 
 	fmt.Printf("txn in block written: %d\n", stat.NumTxnInBlock())
-	fmt.Printf("bytes in block written: %.1f%% of 1MB\n", float64(stat.NumBytesInBlock())/BlocksPool_ITEM*100)
+	fmt.Printf("bytes in block written: %.1f%% of max\n", float64(stat.NumBytesInBlock())/float64(BlocksPool_ITEM)*100)
 	fmt.Printf("block time: %.2fsec\n", stat.dtime)
 	fmt.Printf("Db file size: %.1fMB\n", float64(OsFileBytes(ledger.dbPath))/1024.0/1024.0)
 	fmt.Printf("Avg db bytes/txn: %.dB\n", OsTrn(stat.sum_txns > 0, int(OsFileBytes(ledger.dbPath))/stat.sum_txns, 0))
@@ -79,44 +88,89 @@ type Node struct {
 
 	blockRaw BlockRaw
 	block    TBuffer
-	txn      TBuffer
 
 	stat NodeStat
 
 	blocksFile           *os.File
 	NUMBER_TXNS_IN_BLOCK int
 
+	identity  *NodeIdentity
+	allowList *AllowList
+
+	network_id   string
+	genesis_hash []byte
+	port         int
+	hello_nonce  int64
+
+	verifying atomic.Bool
+
+	create_lock  sync.Mutex   // node.block is used by Loop() and Generate()
+	regtest_time atomic.Int64 // unix
+
 	thread OsThread
 }
 
-func NewNode(ssl_on bool, port int, dbPath string, NUMBER_TXNS_IN_BLOCK int, genesis_amount int64, genesis_pubKey *BLSPubKey, blocksPath string) (*Node, error) {
+func NewNode(tlsConf *NetTLS, port int, peers []string, seeds []string, dbPath string, NUMBER_TXNS_IN_BLOCK int, genesis *Genesis, blocksPath string, limits NetLimits) (*Node, error) {
 	var node Node
-	var err error
+
+	err := genesis.Check()
+	if err != nil {
+		return nil, fmt.Errorf("NewNode() genesis failed: %w", err)
+	}
+
+	node.network_id = genesis.ChainId
+	node.genesis_hash = genesis.Hash()
+	node.port = port
+	node.hello_nonce = rand.Int63()
 
 	node.ledger, err = NewLedger(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("NewNode() NewLedger failed: %w", err)
 	}
 
-	node.net, err = NewNet(ssl_on, port)
+	addrBook, err := NewAddrBook(filepath.Join(filepath.Dir(dbPath), "peers.json"), seeds)
+	if err != nil {
+		return nil, fmt.Errorf("NewNode() NewAddrBook failed: %w", err)
+	}
+
+	banList, err := NewBanList(filepath.Join(filepath.Dir(dbPath), "banlist.json"))
+	if err != nil {
+		return nil, fmt.Errorf("NewNode() NewBanList failed: %w", err)
+	}
+
+	node.identity, err = NewNodeIdentity(filepath.Join(filepath.Dir(dbPath), "node_key"))
+	if err != nil {
+		return nil, fmt.Errorf("NewNode() NewNodeIdentity failed: %w", err)
+	}
+	node.allowList, err = NewAllowList(filepath.Join(filepath.Dir(dbPath), "allowlist.json"))
+	if err != nil {
+		return nil, fmt.Errorf("NewNode() NewAllowList failed: %w", err)
+	}
+	fmt.Printf("Node key: %x\n", node.identity.pubKey.arr)
+
+	node.net, err = NewNet(&node, tlsConf, port, addrBook, banList, limits)
 	if err != nil {
 		return nil, fmt.Errorf("NewNode() NewNet failed: %w", err)
 	}
 
 	node.NUMBER_TXNS_IN_BLOCK = NUMBER_TXNS_IN_BLOCK
 
-	// adds genesis account
-	ac_id, err := node.ledger.accounts.Add(genesis_pubKey)
-	if err != nil {
-		return nil, fmt.Errorf("NewNode() Add() genesis account failed: %w", err)
+	if NetParams_Active.Regtest {
+		numBlocks, err := node.ledger.NumBlocks()
+		if err != nil {
+			return nil, fmt.Errorf("NewNode() NumBlocks failed: %w", err)
+		}
+		node.regtest_time.Store(genesis.Timestamp + numBlocks*Node_REGTEST_BLOCK_TIME)
+		node.net.txnTracker.now = node.Time
 	}
 
-	ac, err := node.ledger.accounts.Get(ac_id)
+	// adds genesis accounts into new ledger
+	node.ledger.lock.Lock()
+	err = node.ledger.AddGenesis(genesis)
+	node.ledger.lock.Unlock()
 	if err != nil {
-		return nil, fmt.Errorf("NewNode() Get() genesis account failed: %w", err)
+		return nil, fmt.Errorf("NewNode() %w", err)
 	}
-	ac.amount = genesis_amount
-	ac.nonce = 0
 
 	if len(blocksPath) > 0 {
 		node.blocksFile, err = os.OpenFile(blocksPath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
@@ -127,6 +181,10 @@ func NewNode(ssl_on bool, port int, dbPath string, NUMBER_TXNS_IN_BLOCK int, gen
 
 	go node.Loop()
 
+	for _, addr := range peers {
+		node.net.Connect(addr)
+	}
+
 	return &node, nil
 }
 
@@ -148,72 +206,262 @@ func (node *Node) Destroy() {
 	}
 }
 
+// returns handshake message with current height
+func (node *Node) Hello() (*NetHello, error) {
+	node.ledger.lock.RLock()
+	numBlocks, err := node.ledger.NumBlocks()
+	node.ledger.lock.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("Hello() failed: %w", err)
+	}
+
+	return NewNetHello(node.network_id, node.genesis_hash, numBlocks-1, node.port, node.hello_nonce, &node.identity.pubKey), nil
+}
+
+func (node *Node) GetBlockByHash(hash []byte) ([]byte, bool) {
+	node.ledger.lock.RLock()
+	defer node.ledger.lock.RUnlock()
+
+	height, err := node.ledger.FindBlock(hash)
+	if err != nil {
+		return nil, false
+	}
+	_, data, err := node.ledger.GetBlock(height)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
 func (node *Node) CreateBlock() error {
 
-	if node.net.txnsPool.Num() > 0 {
+	// in permissioned network only allowed nodes create blocks
+	if !node.allowList.IsAllowed(&node.identity.pubKey) {
+		return nil
+	}
 
-		node.stat.Start()
-		node.ledger.BatchStart()
+	// blocks are downloaded from peers during sync
+	if node.net.txnsPool.Num() > 0 && !node.net.syncer.IsActive() {
+		_, _, err := node._createBlock(true)
+		return err
+	}
 
-		// add txns into new block
-		var absErr error
+	return nil
+}
 
-		for node.blockRaw.NumTxns() < node.NUMBER_TXNS_IN_BLOCK { // or timeout ...
+// wait=true waits until pool has txns for full block, wait=false takes only txns which are in pool now(block can be empty). Returns height and hash of block
+func (node *Node) _createBlock(wait bool) (int64, []byte, error) {
 
-			for node.net.txnsPool.Num() == 0 { // or timeout ...
-				time.Sleep(1 * time.Millisecond)
-			}
+	// waits without ledger.lock, so readers aren't blocked
+	for wait && node.net.txnsPool.Num() < node.NUMBER_TXNS_IN_BLOCK { // or timeout ...
+		time.Sleep(1 * time.Millisecond)
+	}
 
-			txn, err := node.net.txnsPool.Get()
-			if err != nil {
-				absErr = fmt.Errorf("CreateBlock() Get() failed: %w", err)
-				break
-			}
-			node.txn.WriteSBlob(txn)
+	node.stat.Start()
+
+	// lock is held until commit, so nobody reads accounts from unfinished block
+	node.ledger.lock.Lock()
+	err := node.ledger.BatchStart()
+	if err != nil {
+		node.ledger.lock.Unlock()
+		return -1, nil, fmt.Errorf("CreateBlock() failed: %w", err)
+	}
+
+	// add txns into new block
+	var absErr error
+	var taken [][]byte                  // txns in block, they go back to pool when block isn't created
+	var left [][]byte                   // valid txns which wait for next block
+	future := make(map[[2]int64][]byte) // txns after missing nonce by src id and nonce, verifier can reorder txns of same account
+	var next []byte                     // future txn which got its nonce
+
+	for node.blockRaw.NumTxns() < node.NUMBER_TXNS_IN_BLOCK && (next != nil || node.net.txnsPool.Num() > 0) {
 
-			isFull, err := node.blockRaw.AddTxn(&node.txn, BlocksPool_ITEM, &node.block, node.ledger)
+		txn := next
+		next = nil
+		if txn == nil {
+			txn, err = node.net.txnsPool.Get()
 			if err != nil {
-				absErr = fmt.Errorf("CreateBlock() AddTxn() failed: %w", err)
+				absErr = fmt.Errorf("CreateBlock() Get() failed: %w", err)
 				break
 			}
-			if isFull {
-				break
+		}
+		isFull, err := node.blockRaw.AddTxn(NewTBuffer(txn), BlocksPool_ITEM, &node.block, node.ledger)
+		if errors.Is(err, BlockRaw_ErrTxnFuture) {
+			src_id, nonce, _ := PoolTxns_Src(txn) // parsed by AddTxn()
+			_, found := future[[2]int64{src_id, nonce}]
+			if found {
+				left = append(left, txn) // other txn with same nonce
+			} else {
+				future[[2]int64{src_id, nonce}] = txn
 			}
-			//... node.net.txnsPool.Add(node.txn.data[:node.txn.size]) // returns txn back to pool
+			continue
 		}
-
-		if absErr == nil {
-			node.ledger.BatchCommit()
-		} else {
-			node.ledger.BatchRollback()
-			node.ledger.accounts.Rollback() //? ...
-			return absErr
+		if errors.Is(err, BlockRaw_ErrTxnRejected) {
+			node._dropTxn(txn, err.Error()) // ledger wasn't changed, block continues without txn
+			continue
+		}
+		if err != nil {
+			absErr = fmt.Errorf("CreateBlock() AddTxn() failed: %w", err)
+			node._dropTxn(txn, err.Error())
+			break
 		}
+		if isFull {
+			left = append(left, txn)
+			break
+		}
+		taken = append(taken, txn)
 
-		// finish block
-		node.blockRaw.Finish(&node.block)
-		// BlocksPool_addBlock(node.net.blocksPool, node.block)
-		if node.blocksFile != nil {
+		src_id, nonce, _ := PoolTxns_Src(txn)
+		next = future[[2]int64{src_id, nonce + 1}]
+		delete(future, [2]int64{src_id, nonce + 1})
+	}
 
-			err := Client_WriteInt(node.blocksFile, node.block.size)
-			if err != nil {
-				return fmt.Errorf("CreateBlock() Client_WriteInt() failed: %w", err)
-			}
-			_, err = node.blocksFile.Write(node.block.data[:node.block.size])
-			if err != nil {
-				return fmt.Errorf("CreateBlock() Write() failed: %w", err)
-			}
+	// future txns go back in nonce order
+	if next != nil {
+		left = append(left, next)
+	}
+	keys := make([][2]int64, 0, len(future))
+	for k := range future {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+	for _, k := range keys {
+		left = append(left, future[k])
+	}
+
+	// waiting for full block doesn't create empty one, when pool has only future txns
+	if absErr == nil && wait && len(taken) == 0 {
+		node.ledger.BatchRollback()
+		node.ledger.lock.Unlock()
+		node.net.txnsPool.Return(left)
+		node.blockRaw.ResetAndPrepare(&node.block)
+		time.Sleep(10 * time.Millisecond)
+		return -1, nil, nil
+	}
+
+	// finish block
+	if absErr == nil {
+		err := node.blockRaw.Finish(&node.block)
+		if err != nil {
+			absErr = fmt.Errorf("CreateBlock() Finish() failed: %w", err)
 		}
+	}
 
-		node.stat.End(int(node.block.size), node.blockRaw.NumTxns())
-		node.stat.Print(node.ledger)
+	var height int64
+	if absErr == nil {
+		var err error
+		height, err = node.ledger.AddBlock(node.block.data[:node.block.size], node.blockRaw.hashes)
+		if err != nil {
+			absErr = fmt.Errorf("CreateBlock() AddBlock() failed: %w", err)
+		}
+	}
+
+	if absErr == nil {
+		err := node.ledger.BatchCommit()
+		if err != nil {
+			absErr = fmt.Errorf("CreateBlock() failed: %w", err)
+		}
+	}
+	if absErr != nil {
+		node.ledger.BatchRollback()
+	}
+	node.ledger.lock.Unlock()
+	if absErr != nil {
+		node.net.txnsPool.Return(append(taken, left...)) // only txn which failed was dropped
 		node.blockRaw.ResetAndPrepare(&node.block)
+		return -1, nil, absErr
 	}
+	node.net.txnsPool.Return(left)
+	node.net.txnTracker.OnBlock(node.blockRaw.hashes, height)
 
-	return nil
+	// announces block to peers
+	data := append([]byte(nil), node.block.data[:node.block.size]...)
+	hash, err := TBuffer_sha256(data)
+	if err != nil {
+		return -1, nil, fmt.Errorf("CreateBlock() sha256 failed: %w", err)
+	}
+	node.net.events.OnBlock(height, hash, data)
+	node.net.Relay(INV_BLOCK, [32]byte(hash), data)
+
+	if node.blocksFile != nil {
+
+		err := Client_WriteInt(node.blocksFile, node.block.size)
+		if err != nil {
+			return -1, nil, fmt.Errorf("CreateBlock() Client_WriteInt() failed: %w", err)
+		}
+		_, err = node.blocksFile.Write(node.block.data[:node.block.size])
+		if err != nil {
+			return -1, nil, fmt.Errorf("CreateBlock() Write() failed: %w", err)
+		}
+	}
+
+	node.stat.End(int(node.block.size), node.blockRaw.NumTxns())
+	node.stat.Print(node.ledger)
+	node.blockRaw.ResetAndPrepare(&node.block)
+
+	return height, hash, nil
+}
+
+// regtest: creates blocks now from txns which are in pool, blocks can be empty. Returns heights and hashes of new blocks.
+// reward(can be nil) is checked, blocks don't carry reward or fees yet, so it isn't credited
+func (node *Node) Generate(num int, reward *Address) ([]int64, [][]byte, error) {
+
+	if !NetParams_Active.Regtest {
+		return nil, nil, errors.New("Generate() is only for regtest")
+	}
+	if reward != nil && reward.IsShort() {
+		node.ledger.lock.RLock()
+		_, err := node.ledger.accounts.Get(int(reward.id))
+		node.ledger.lock.RUnlock()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Generate() reward account(%d) failed: %w", reward.id, err)
+		}
+	}
+
+	node.create_lock.Lock()
+	defer node.create_lock.Unlock()
+
+	var heights []int64
+	var hashes [][]byte
+	for i := 0; i < num; i++ {
+		height, hash, err := node._createBlock(false)
+		if err != nil {
+			return heights, hashes, fmt.Errorf("Generate() failed: %w", err)
+		}
+		heights = append(heights, height)
+		hashes = append(hashes, hash)
+		node.regtest_time.Add(Node_REGTEST_BLOCK_TIME)
+	}
+	return heights, hashes, nil
+}
+
+// unix time. Regtest clock starts at genesis timestamp and moves only with generated blocks
+func (node *Node) Time() int64 {
+	if NetParams_Active.Regtest {
+		return node.regtest_time.Load()
+	}
+	return time.Now().Unix()
+}
+
+// txn was taken from pool, but it's not in block
+func (node *Node) _dropTxn(txn []byte, reason string) {
+	id, err := Server_TxnId(txn)
+	if err == nil {
+		node.net.txnTracker.Drop(id, reason)
+	}
+}
+
+// true while block from pool is being checked
+func (node *Node) IsVerifying() bool {
+	return node.verifying.Load()
 }
 
 func (node *Node) VerifyBlock() error {
+	node.verifying.Store(true)
+	defer node.verifying.Store(false)
+
 	block, err := node.net.blocksPool.Get()
 	if err != nil {
 		//	return fmt.Errorf("VerifyBlock() Get() failed: %w", err)
@@ -224,10 +472,34 @@ func (node *Node) VerifyBlock() error {
 
 	node.stat.Start()
 
-	err = node.blockRaw.CheckAndWrite(&node.block, node.ledger)
+	hash, err := TBuffer_sha256(block)
+	if err != nil {
+		return fmt.Errorf("VerifyBlock() sha256 failed: %w", err)
+	}
+
+	node.ledger.lock.Lock()
+	err = node.blockRaw.CheckAndWrite(&node.block, node.ledger, node.net.signCache)
+	numBlocks := int64(0)
+	if err == nil {
+		numBlocks, err = node.ledger.NumBlocks()
+		if err != nil {
+			node.ledger.lock.Unlock()
+			return fmt.Errorf("VerifyBlock() failed: %w", err)
+		}
+	}
+	node.ledger.lock.Unlock()
 	if err != nil {
+		// competing or unconnectable block isn't peer's fault
+		if errors.Is(err, BlockRaw_ErrInvalid) {
+			node.net.OnBadBlock([32]byte(hash))
+		}
 		return fmt.Errorf("VerifyBlock() CheckAndWrite() failed: %w", err)
 	}
+	node.net.txnTracker.OnBlock(node.blockRaw.hashes, numBlocks-1)
+	node.net.events.OnBlock(numBlocks-1, hash, block)
+
+	node.net.txnsPool.Remove(node.blockRaw.hashes)
+	node.net.Relay(INV_BLOCK, [32]byte(hash), block)
 
 	node.stat.End(int(node.block.size), node.blockRaw.NumTxns())
 	node.stat.Print(node.ledger)
@@ -242,14 +514,25 @@ func (node *Node) Loop() {
 	node.blockRaw.ResetAndPrepare(&node.block)
 
 	for node.thread.Is() {
-		err := node.CreateBlock()
-		if err != nil {
-			log.Printf("Loop() CreateBlock() failed: %v\n", err)
+		node.create_lock.Lock()
+
+		// regtest creates blocks only with Generate()
+		if !NetParams_Active.Regtest {
+			err := node.CreateBlock()
+			if err != nil {
+				log.Printf("Loop() CreateBlock() failed: %v\n", err)
+			}
 		}
 
-		err = node.VerifyBlock()
+		err := node.VerifyBlock()
 		if err != nil {
 			log.Printf("Loop() VerifyBlock() failed: %v\n", err)
 		}
+
+		node.create_lock.Unlock()
+
+		if NetParams_Active.Regtest {
+			time.Sleep(1 * time.Millisecond) // Generate() can take lock
+		}
 	}
 }
@@ -0,0 +1,148 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Consensus parameters of network. Nodes with different parameters(hash) refuse to talk together
+type NetParams struct {
+	Name      string `json:"name"`
+	NetworkId string `json:"networkId"`
+	Port      int    `json:"port"`    // default port, not part of hash
+	Genesis   string `json:"genesis"` // genesis file in data dir, not part of hash(genesis hash is in hello)
+	Regtest   bool   `json:"regtest"` // blocks are created only on demand(generate), deterministic clock
+
+	AddrPrefix string `json:"addrPrefix"` // address prefix, not part of hash
+
+	MaxBlockBytes int   `json:"maxBlockBytes"`
+	NumAggSigns   int   `json:"numAggSigns"` // aggregated signitures in block
+	AdjustSrcId   int64 `json:"adjustSrcId"` // TxnRaw offsets
+	AdjustDstId   int64 `json:"adjustDstId"`
+	AdjustAmount  int64 `json:"adjustAmount"`
+}
+
+// parameters which node runs with
+var NetParams_Active = NetParams_Mainnet()
+
+func NetParams_Mainnet() NetParams {
+	return NetParams{
+		Name:          "mainnet",
+		NetworkId:     Net_NETWORK_DEFAULT,
+		Port:          4879,
+		Genesis:       "genesis.json",
+		AddrPrefix:    "tin",
+		MaxBlockBytes: 1024 * 1024,
+		NumAggSigns:   8,
+		AdjustSrcId:   1000000,
+		AdjustDstId:   1000000,
+		AdjustAmount:  1000000,
+	}
+}
+
+func NetParams_Testnet() NetParams {
+	p := NetParams_Mainnet()
+	p.Name = "testnet"
+	p.NetworkId = Net_NETWORK_DEFAULT + "-test"
+	p.Port = 14879
+	p.AddrPrefix = "ttin"
+	return p
+}
+
+func NetParams_Regtest() NetParams {
+	p := NetParams_Mainnet()
+	p.Name = "regtest"
+	p.NetworkId = Net_NETWORK_DEFAULT + "-regtest"
+	p.Port = 24879
+	p.AddrPrefix = "rtin"
+	p.NumAggSigns = 2 // blocks are small
+	p.Regtest = true
+	return p
+}
+
+var NetParams_SETS = map[string]func() NetParams{
+	"mainnet": NetParams_Mainnet,
+	"testnet": NetParams_Testnet,
+	"regtest": NetParams_Regtest,
+}
+
+func NetParams_Get(name string) (NetParams, error) {
+	fn, found := NetParams_SETS[name]
+	if !found {
+		var names []string
+		for n := range NetParams_SETS {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return NetParams{}, fmt.Errorf("NetParams_Get() unknown network '%s', expected one of %v", name, names)
+	}
+	return fn(), nil
+}
+
+func (p *NetParams) Check() error {
+	if len(p.NetworkId) == 0 {
+		return fmt.Errorf("NetParams.Check() network id is empty")
+	}
+	if len(p.AddrPrefix) == 0 || strings.ToLower(p.AddrPrefix) != p.AddrPrefix || strings.Contains(p.AddrPrefix, "1") {
+		return fmt.Errorf("NetParams.Check() addrPrefix(%s) must be lowercase without '1'", p.AddrPrefix)
+	}
+	if p.MaxBlockBytes < 1024 {
+		return fmt.Errorf("NetParams.Check() maxBlockBytes(%d) is too small", p.MaxBlockBytes)
+	}
+	if p.NumAggSigns < 1 || p.NumAggSigns > 256 {
+		return fmt.Errorf("NetParams.Check() numAggSigns(%d) must be 1-256", p.NumAggSigns)
+	}
+	if p.AdjustSrcId < 0 || p.AdjustDstId < 0 || p.AdjustAmount < 0 {
+		return fmt.Errorf("NetParams.Check() adjust offsets can't be negative")
+	}
+	return nil
+}
+
+// hash of all consensus parameters, it's sent in hello
+func (p *NetParams) Hash() [32]byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(int64(len(p.NetworkId)))
+	buff.WriteSBlob([]byte(p.NetworkId))
+	buff.WriteUint8(uint8(OsTrn(p.Regtest, 1, 0)))
+	buff.WriteNumber(int64(p.MaxBlockBytes))
+	buff.WriteNumber(int64(p.NumAggSigns))
+	buff.WriteNumber(p.AdjustSrcId)
+	buff.WriteNumber(p.AdjustDstId)
+	buff.WriteNumber(p.AdjustAmount)
+
+	h, _ := TBuffer_sha256(buff.data[:buff.size])
+	return [32]byte(h)
+}
+
+// sets parameters for whole process. Must be called before node or client is created
+func (p *NetParams) Apply() error {
+	err := p.Check()
+	if err != nil {
+		return err
+	}
+
+	NetParams_Active = *p
+	BlocksPool_ITEM = p.MaxBlockBytes
+	BlockVerMT_NUM_AGG_SIGNITURES = p.NumAggSigns
+	TxnRaw_ADJUST_SRC_ID = p.AdjustSrcId
+	TxnRaw_ADJUST_DST_ID = p.AdjustDstId
+	TxnRaw_ADJUST_AMOUNT = p.AdjustAmount
+	return nil
+}
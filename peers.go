@@ -0,0 +1,833 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const Peer_KNOWN_MAX = 100000
+const Peer_WRITE_TIMEOUT = 10 * time.Second // slow peer doesn't block writer forever
+
+const Peers_RECONNECT_MIN = 1 * time.Second
+const Peers_RECONNECT_MAX = 60 * time.Second
+
+const Peers_OUTBOUND_MAX = 8
+const Peers_MANAGER_TICK = 5 * time.Second
+const Peers_SAVE_TICK = 60 * time.Second
+
+type NetCacheItem struct {
+	hash [32]byte
+	data []byte
+}
+
+// Bounded set of hashes(with optional data), the oldest items are removed first
+type NetCache struct {
+	lock sync.Mutex
+
+	items map[[32]byte]*NetCacheItem
+	order []*NetCacheItem // can contain removed items, they are skipped
+	max   int
+}
+
+func NewNetCache(max int) *NetCache {
+	var self NetCache
+	self.items = make(map[[32]byte]*NetCacheItem)
+	self.max = max
+	return &self
+}
+
+// returns false if hash is already in cache
+func (cache *NetCache) Add(hash [32]byte, data []byte) bool {
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	_, found := cache.items[hash]
+	if found {
+		return false
+	}
+
+	for len(cache.items) >= cache.max && len(cache.order) > 0 {
+		it := cache.order[0]
+		cache.order = cache.order[1:]
+		if cache.items[it.hash] == it {
+			delete(cache.items, it.hash)
+		}
+	}
+
+	it := &NetCacheItem{hash: hash, data: data}
+	cache.items[hash] = it
+	cache.order = append(cache.order, it)
+
+	// drops removed items from order
+	if len(cache.order) > 2*len(cache.items)+1024 {
+		order := make([]*NetCacheItem, 0, len(cache.items))
+		for _, it := range cache.order {
+			if cache.items[it.hash] == it {
+				order = append(order, it)
+			}
+		}
+		cache.order = order
+	}
+	return true
+}
+
+// item stays in order until it's skipped by Add()
+func (cache *NetCache) Remove(hash [32]byte) {
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	delete(cache.items, hash)
+}
+
+func (cache *NetCache) Has(hash [32]byte) bool {
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	_, found := cache.items[hash]
+	return found
+}
+
+func (cache *NetCache) Get(hash [32]byte) ([]byte, bool) {
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	it, found := cache.items[hash]
+	if !found {
+		return nil, false
+	}
+	return it.data, true
+}
+
+const NetRequests_TIMEOUT = 10 * time.Second
+const NetRequests_PEERS_MAX = 8 // other peers which announced item
+
+type NetRequest struct {
+	inv_type uint8 // INV_TXN or INV_BLOCK
+	peer     *Peer // asked peer
+	time     time.Time
+	others   []*Peer // announced it too, asked when peer doesn't answer
+}
+
+// Items asked by MSG_GETDATA. Item which doesn't arrive in time is asked from other peer which announced it
+type NetRequests struct {
+	lock sync.Mutex
+
+	items map[[32]byte]*NetRequest
+	max   int
+}
+
+func NewNetRequests(max int) *NetRequests {
+	var self NetRequests
+	self.items = make(map[[32]byte]*NetRequest)
+	self.max = max
+	return &self
+}
+
+// returns true if item should be asked from peer now, otherwise peer is kept for retry
+func (reqs *NetRequests) Add(inv_type uint8, hash [32]byte, peer *Peer) bool {
+
+	reqs.lock.Lock()
+	defer reqs.lock.Unlock()
+
+	req, found := reqs.items[hash]
+	if found {
+		if req.peer == peer || len(req.others) >= NetRequests_PEERS_MAX {
+			return false
+		}
+		for _, p := range req.others {
+			if p == peer {
+				return false
+			}
+		}
+		req.others = append(req.others, peer)
+		return false
+	}
+
+	if len(reqs.items) >= reqs.max {
+		var oldest [32]byte
+		var oldest_time time.Time
+		for h, it := range reqs.items {
+			if oldest_time.IsZero() || it.time.Before(oldest_time) {
+				oldest = h
+				oldest_time = it.time
+			}
+		}
+		delete(reqs.items, oldest)
+	}
+	reqs.items[hash] = &NetRequest{inv_type: inv_type, peer: peer, time: time.Now()}
+	return true
+}
+
+// removes item which arrived from peer we asked, returns false when item wasn't asked from this peer
+func (reqs *NetRequests) Take(hash [32]byte, peer *Peer) bool {
+
+	reqs.lock.Lock()
+	defer reqs.lock.Unlock()
+
+	req, found := reqs.items[hash]
+	if !found || req.peer != peer {
+		return false
+	}
+	delete(reqs.items, hash)
+	return true
+}
+
+// removes items which arrived, returns timeouted items(inv format) grouped by next peer which should be asked
+func (reqs *NetRequests) Expire(arrived func(hash [32]byte) bool, connected map[*Peer]bool) map[*Peer][]byte {
+
+	reqs.lock.Lock()
+	defer reqs.lock.Unlock()
+
+	now := time.Now()
+	ret := make(map[*Peer][]byte)
+	for hash, req := range reqs.items {
+		if arrived(hash) {
+			delete(reqs.items, hash)
+			continue
+		}
+		if now.Sub(req.time) < NetRequests_TIMEOUT {
+			continue
+		}
+
+		req.peer = nil
+		for len(req.others) > 0 && req.peer == nil {
+			if connected[req.others[0]] {
+				req.peer = req.others[0]
+			}
+			req.others = req.others[1:]
+		}
+		if req.peer == nil {
+			delete(reqs.items, hash) // asked again when it's announced again
+			continue
+		}
+		req.time = now
+		ret[req.peer] = append(append(ret[req.peer], req.inv_type), hash[:]...)
+	}
+	return ret
+}
+
+// One websocket link, inbound(accepted by Server) or outbound(dialed by Server.Connect)
+type Peer struct {
+	conn        *websocket.Conn
+	addr        string
+	listen_addr string // where peer accepts connections, empty for clients
+	ban_key     string // node key of authenticated node, IP of anonymous one
+	ip          string // anonymous peers from same IP share txns rate, empty for authenticated
+	inbound     bool
+	hello       *NetHello
+
+	height atomic.Int64 // best known height of peer
+
+	txnBucket *TokenBucket
+
+	write_lock sync.Mutex
+
+	known *NetCache // inventory which peer already has
+
+	inv_lock sync.Mutex
+	inv      []byte // inventory waiting for announcement
+}
+
+func NewPeer(conn *websocket.Conn, addr string, inbound bool, hello *NetHello, limits *NetLimits) *Peer {
+	var peer Peer
+	peer.conn = conn
+	peer.addr = addr
+	peer.inbound = inbound
+	peer.hello = hello
+	peer.known = NewNetCache(Peer_KNOWN_MAX)
+	peer.height.Store(hello.height)
+	peer.txnBucket = NewTokenBucket(limits.txns_per_sec_conn)
+	conn.SetReadLimit(limits.MaxFrame())
+
+	if !inbound {
+		peer.listen_addr = addr
+	} else if hello.port > 0 && peer.IsRelay() {
+		host, _, err := net.SplitHostPort(addr)
+		if err == nil {
+			peer.listen_addr = net.JoinHostPort(host, strconv.Itoa(int(hello.port)))
+		}
+	}
+
+	// authenticated nodes behind same IP(NAT, localhost cluster) are scored and limited separately,
+	// anonymous peer can change port with every reconnect, so it's scored by IP
+	if hello.HasIdentity() {
+		peer.ban_key = NodeIdentity_BanKey(&hello.pubKey)
+	} else {
+		peer.ip = BanList_Key(addr)
+		peer.ban_key = peer.ip
+	}
+	return &peer
+}
+
+func (peer *Peer) Write(msg []byte) error {
+
+	peer.write_lock.Lock()
+	defer peer.write_lock.Unlock()
+
+	peer.conn.SetWriteDeadline(time.Now().Add(Peer_WRITE_TIMEOUT))
+	return peer.conn.WriteMessage(websocket.BinaryMessage, msg)
+}
+
+// writes from own goroutine, so caller(verifier worker) isn't blocked by slow peer
+func (peer *Peer) WriteAsync(msg []byte) {
+	go func() {
+		err := peer.Write(msg)
+		if err != nil {
+			log.Printf("Error: Write() failed: %v", err)
+		}
+	}()
+}
+
+func (peer *Peer) Close() {
+
+	peer.write_lock.Lock()
+	defer peer.write_lock.Unlock()
+
+	peer.conn.SetWriteDeadline(time.Now().Add(Peer_WRITE_TIMEOUT))
+	peer.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	peer.conn.Close()
+}
+
+func (peer *Peer) IsRelay() bool {
+	return peer.hello.msgs&Net_RELAY_MSGS == Net_RELAY_MSGS
+}
+
+// queues announcement, returns false if peer already knows item
+func (peer *Peer) Announce(inv_type uint8, hash [32]byte) bool {
+
+	if !peer.known.Add(hash, nil) {
+		return false
+	}
+
+	peer.inv_lock.Lock()
+	defer peer.inv_lock.Unlock()
+
+	peer.inv = append(peer.inv, inv_type)
+	peer.inv = append(peer.inv, hash[:]...)
+	return true
+}
+
+// sends queued announcements
+func (peer *Peer) FlushInv() error {
+
+	peer.inv_lock.Lock()
+	items := peer.inv
+	peer.inv = nil
+	peer.inv_lock.Unlock()
+
+	// split into frames which fits into NetLimits.max_other_frame
+	for len(items) > 0 {
+		n := OsMin(len(items), NetInv_MAX*NetInv_ITEM_SIZE)
+		err := peer.Write(NetInv_Serialize(MSG_INV, items[:n]))
+		if err != nil {
+			return err
+		}
+		items = items[n:]
+	}
+	return nil
+}
+
+// tlsConf can be nil(without TLS)
+func Net_Dial(addr string, path string, tlsConf *NetTLS) (*websocket.Conn, error) {
+
+	var ssl_proto string
+	if tlsConf != nil {
+		ssl_proto = "wss"
+	} else {
+		ssl_proto = "ws"
+	}
+
+	u := url.URL{Scheme: ssl_proto, Host: addr, Path: "/" + path} //wss = for SSL
+
+	var c *websocket.Conn
+	var err error
+	if tlsConf != nil {
+		var conf *tls.Config
+		conf, err = tlsConf.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("Net_Dial() failed: %w", err)
+		}
+		d := websocket.Dialer{TLSClientConfig: conf, HandshakeTimeout: Server_HELLO_TIMEOUT}
+		c, _, err = d.Dial(u.String(), nil)
+	} else {
+		c, _, err = websocket.DefaultDialer.Dial(u.String(), nil) //without SSL
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Net_Dial(): Failed to connect to %s with error: %w", u.String(), err)
+	}
+	return c, nil
+}
+
+// sends our hello and waits for remote one. If remote hello has pubKey, it must prove it. identity can be nil(anonymous)
+func Net_ClientHandshake(c *websocket.Conn, own *NetHello, req_id uint64, identity *NodeIdentity) (*NetHello, error) {
+
+	// fresh challenge for every connection
+	h := *own
+	own = &h
+	own.challenge = NodeIdentity_Challenge()
+
+	err := c.WriteMessage(websocket.BinaryMessage, own.Serialize(req_id))
+	if err != nil {
+		return nil, fmt.Errorf("Net_ClientHandshake() WriteMessage() failed: %w", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(Server_HELLO_TIMEOUT))
+	defer c.SetReadDeadline(time.Time{})
+
+	mt, message, err := c.ReadMessage()
+	if err != nil {
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return nil, fmt.Errorf("Net_ClientHandshake() refused by server: %s", closeErr.Text)
+		}
+		return nil, fmt.Errorf("Net_ClientHandshake() ReadMessage() failed: %w", err)
+	}
+	if mt != websocket.BinaryMessage {
+		return nil, errors.New("Net_ClientHandshake() message is not binary")
+	}
+
+	var hello NetHello
+	_, err = hello.Deserialize(message)
+	if err != nil {
+		return nil, fmt.Errorf("Net_ClientHandshake() failed: %w", err)
+	}
+
+	err = hello.Check(own)
+	if err != nil {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+		return nil, fmt.Errorf("Net_ClientHandshake() server refused: %w", err)
+	}
+
+	// client proves itself first, server answers only to authenticated client
+	err = Net_WriteAuth(c, identity, NodeIdentity_AuthMsg(NodeIdentity_ROLE_CLIENT, own, &hello), req_id)
+	if err != nil {
+		return nil, fmt.Errorf("Net_ClientHandshake() failed: %w", err)
+	}
+	err = Net_ReadAuth(c, &hello, NodeIdentity_AuthMsg(NodeIdentity_ROLE_SERVER, own, &hello))
+	if err != nil {
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return nil, fmt.Errorf("Net_ClientHandshake() refused by server: %s", closeErr.Text)
+		}
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication failed"))
+		return nil, fmt.Errorf("Net_ClientHandshake() failed: %w", err)
+	}
+
+	return &hello, nil
+}
+
+// signs msg(NodeIdentity_AuthMsg). Does nothing for anonymous side
+func Net_WriteAuth(c *websocket.Conn, identity *NodeIdentity, msg []byte, req_id uint64) error {
+	if identity == nil {
+		return nil
+	}
+	sign, err := identity.Sign(msg)
+	if err != nil {
+		return fmt.Errorf("Net_WriteAuth() failed: %w", err)
+	}
+	err = c.WriteMessage(websocket.BinaryMessage, NetAuth_Serialize(req_id, sign))
+	if err != nil {
+		return fmt.Errorf("Net_WriteAuth() WriteMessage() failed: %w", err)
+	}
+	return nil
+}
+
+// checks that remote owns pubKey from its hello. Does nothing for anonymous remote
+func Net_ReadAuth(c *websocket.Conn, remote *NetHello, msg []byte) error {
+	if !remote.HasIdentity() {
+		return nil
+	}
+	mt, message, err := c.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("Net_ReadAuth() ReadMessage() failed: %w", err)
+	}
+	if mt != websocket.BinaryMessage {
+		return errors.New("Net_ReadAuth() message is not binary")
+	}
+	sign, err := NetAuth_Deserialize(message)
+	if err != nil {
+		return fmt.Errorf("Net_ReadAuth() failed: %w", err)
+	}
+	if !NodeIdentity_Verify(&remote.pubKey, msg, sign) {
+		return errors.New("Net_ReadAuth() invalid signature")
+	}
+	return nil
+}
+
+func (net *Server) _addPeer(peer *Peer) {
+	net.peers_lock.Lock()
+	defer net.peers_lock.Unlock()
+
+	net.peers = append(net.peers, peer)
+}
+
+func (net *Server) _removePeer(peer *Peer) {
+	net.peers_lock.Lock()
+	defer net.peers_lock.Unlock()
+
+	for i, p := range net.peers {
+		if p == peer {
+			net.peers = append(net.peers[:i], net.peers[i+1:]...)
+			break
+		}
+	}
+}
+
+func (net *Server) GetPeers() []*Peer {
+	net.peers_lock.Lock()
+	defer net.peers_lock.Unlock()
+
+	return append([]*Peer(nil), net.peers...)
+}
+
+// keeps outbound connection to addr("host:port"), reconnects when link drops
+func (net *Server) Connect(addr string) {
+	go net._outboundLoop(addr)
+}
+
+func (net *Server) _outboundLoop(addr string) {
+
+	wait := Peers_RECONNECT_MIN
+	for !net.isServerClosed {
+
+		connected, err := net._outbound(addr)
+		if net.isServerClosed {
+			break
+		}
+		if connected {
+			wait = Peers_RECONNECT_MIN
+		}
+		fmt.Printf("Peer %s: %v, reconnecting in %v\n", addr, err, wait)
+
+		time.Sleep(wait)
+		wait = time.Duration(OsMin(int(wait*2), int(Peers_RECONNECT_MAX)))
+	}
+}
+
+// returns true if handshake was successful
+func (net *Server) _outbound(addr string) (bool, error) {
+
+	c, err := Net_Dial(addr, "data", net.tlsConf)
+	if err != nil {
+		net.addrBook.MarkFailed(addr)
+		return false, err
+	}
+
+	own, err := net.node.Hello()
+	if err != nil {
+		c.Close()
+		return false, err
+	}
+
+	hello, err := Net_ClientHandshake(c, own, 0, net.node.identity)
+	if err != nil {
+		c.Close()
+		if errors.Is(err, Net_ErrSelf) {
+			net.addrBook.Remove(addr)
+		} else {
+			net.addrBook.MarkFailed(addr)
+		}
+		return false, err
+	}
+	err = net.CheckNode(hello)
+	if err != nil {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+		c.Close()
+		net.addrBook.MarkFailed(addr)
+		return false, err
+	}
+	net.addrBook.MarkSeen(addr)
+
+	peer := NewPeer(c, addr, false, hello, &net.limits)
+	fmt.Printf("Peer %s connected\n", addr)
+	err = net.PeerLoop(peer)
+	if err == nil {
+		err = errors.New("disconnected")
+	}
+	return true, err
+}
+
+// returns addresses which we are connected to or dialing
+func (net *Server) _connectedAddrs() map[string]bool {
+	net.peers_lock.Lock()
+	defer net.peers_lock.Unlock()
+
+	ret := make(map[string]bool)
+	for _, p := range net.peers {
+		if len(p.listen_addr) > 0 {
+			ret[p.listen_addr] = true
+		}
+	}
+	for addr := range net.dialing {
+		ret[addr] = true
+	}
+	return ret
+}
+
+func (net *Server) _numOutbound() int {
+	net.peers_lock.Lock()
+	defer net.peers_lock.Unlock()
+
+	n := len(net.dialing)
+	for _, p := range net.peers {
+		if !p.inbound {
+			n++
+		}
+	}
+	return n
+}
+
+// keeps number of outbound connections, picks peers from address book
+func (net *Server) _outboundManager() {
+
+	last_save := time.Now()
+	for !net.isServerClosed {
+
+		n := Peers_OUTBOUND_MAX - net._numOutbound()
+		if n > 0 {
+			for _, addr := range net.addrBook.Pick(n, net._connectedAddrs()) {
+				if net.banList.IsBanned(BanList_Key(addr)) {
+					continue
+				}
+
+				net.peers_lock.Lock()
+				net.dialing[addr] = true
+				net.peers_lock.Unlock()
+
+				go func(addr string) {
+					defer func() {
+						net.peers_lock.Lock()
+						delete(net.dialing, addr)
+						net.peers_lock.Unlock()
+					}()
+
+					_, err := net._outbound(addr)
+					if err != nil && !net.isServerClosed {
+						fmt.Printf("Peer %s: %v\n", addr, err)
+					}
+				}(addr)
+			}
+		}
+
+		if time.Since(last_save) > Peers_SAVE_TICK {
+			err := net.addrBook.Save()
+			if err != nil {
+				log.Printf("_outboundManager() failed: %v\n", err)
+			}
+			last_save = time.Now()
+		}
+
+		time.Sleep(Peers_MANAGER_TICK)
+	}
+}
+
+func (net *Server) _onGetAddr(peer *Peer) error {
+	return peer.Write(NetAddr_Serialize(net.addrBook.List(NetAddr_MAX)))
+}
+
+func (net *Server) _onAddr(peer *Peer, addrs []string) {
+	for _, addr := range addrs {
+		net.addrBook.Add(addr, AddrBook_SOURCE_PEER)
+	}
+}
+
+// adds misbehavior score to peer(node key or ip:port), banned peer is disconnected
+func (net *Server) Misbehave(peer *Peer, score int, reason string) {
+	if net.banList.Misbehave(peer.ban_key, score, reason) {
+		for _, p := range net.GetPeers() {
+			if p.ban_key == peer.ban_key {
+				p.Close()
+			}
+		}
+	}
+}
+
+// block from pool failed verification, punishes peer who sent it
+func (net *Server) OnBadBlock(hash [32]byte) {
+	key, found := net.blockSources.Get(hash)
+	if !found {
+		return
+	}
+	for _, p := range net.GetPeers() {
+		if p.ban_key == string(key) {
+			net.Misbehave(p, BAN_SCORE_INVALID_BLOCK, "invalid block")
+			return
+		}
+	}
+	net.banList.Misbehave(string(key), BAN_SCORE_INVALID_BLOCK, "invalid block") // peer is already disconnected
+}
+
+// checks banned node key and allowlist(only for relay peers, clients can send txns)
+func (net *Server) CheckNode(hello *NetHello) error {
+	if hello.HasIdentity() && net.banList.IsBanned(NodeIdentity_BanKey(&hello.pubKey)) {
+		return errors.New("node is banned")
+	}
+	if hello.msgs&Net_RELAY_MSGS != 0 && !net._isNodeAllowed(hello) {
+		return errors.New("node is not allowed")
+	}
+	return nil
+}
+
+// true if peer can send blocks
+func (net *Server) _isNodeAllowed(hello *NetHello) bool {
+	if !net.node.allowList.IsActive() {
+		return true
+	}
+	return hello.HasIdentity() && net.node.allowList.IsAllowed(&hello.pubKey)
+}
+
+// announces new txn/block to all relay peers which don't know it yet
+func (net *Server) Relay(inv_type uint8, hash [32]byte, data []byte) {
+
+	if inv_type == INV_TXN {
+		net.relayTxns.Add(hash, data)
+	} else {
+		net.relayBlocks.Add(hash, data)
+	}
+	net.seen.Add(hash, nil)
+
+	for _, peer := range net.GetPeers() {
+		if peer.IsRelay() {
+			peer.Announce(inv_type, hash)
+		}
+	}
+}
+
+func (net *Server) _relayLoop() {
+	last_retry := time.Now()
+	for !net.isServerClosed {
+		for _, peer := range net.GetPeers() {
+			err := peer.FlushInv()
+			if err != nil {
+				fmt.Printf("Peer %s: FlushInv() failed: %v\n", peer.addr, err)
+			}
+		}
+		if time.Since(last_retry) > time.Second {
+			net._retryRequests()
+			last_retry = time.Now()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// asks other peers for items which didn't arrive in time
+func (net *Server) _retryRequests() {
+	connected := make(map[*Peer]bool)
+	for _, peer := range net.GetPeers() {
+		connected[peer] = true
+	}
+
+	for peer, items := range net.requested.Expire(net.seen.Has, connected) {
+		net._toCmpct(peer, items)
+		err := peer.Write(NetInv_Serialize(MSG_GETDATA, items))
+		if err != nil {
+			fmt.Printf("Peer %s: GetData retry failed: %v\n", peer.addr, err)
+		}
+	}
+}
+
+// block is rebuilt from txns pool, if peer supports compact blocks
+func (net *Server) _toCmpct(peer *Peer, items []byte) {
+	if !peer.hello.IsSupported(MSG_CMPCTBLOCK) {
+		return
+	}
+	for i := 0; i+NetInv_ITEM_SIZE <= len(items); i += NetInv_ITEM_SIZE {
+		if items[i] == INV_BLOCK {
+			items[i] = INV_CMPCTBLOCK
+		}
+	}
+}
+
+// asks for items which we haven't seen yet
+func (net *Server) _onInv(peer *Peer, items []byte) error {
+
+	var request []byte
+	for i := 0; i+NetInv_ITEM_SIZE <= len(items); i += NetInv_ITEM_SIZE {
+		hash := [32]byte(items[i+1 : i+NetInv_ITEM_SIZE])
+		peer.known.Add(hash, nil)
+
+		// Syncer downloads blocks in order
+		if items[i] == INV_BLOCK && net.syncer.IsActive() {
+			continue
+		}
+
+		if net.seen.Has(hash) || !net.requested.Add(items[i], hash, peer) {
+			continue
+		}
+		request = append(request, items[i:i+NetInv_ITEM_SIZE]...)
+	}
+
+	if len(request) == 0 {
+		return nil
+	}
+	net._toCmpct(peer, request)
+	return peer.Write(NetInv_Serialize(MSG_GETDATA, request))
+}
+
+// sends requested items which we have
+func (net *Server) _onGetData(peer *Peer, items []byte) error {
+
+	for i := 0; i+NetInv_ITEM_SIZE <= len(items); i += NetInv_ITEM_SIZE {
+		inv_type := items[i]
+		hash := [32]byte(items[i+1 : i+NetInv_ITEM_SIZE])
+
+		if inv_type == INV_CMPCTBLOCK {
+			err := net._sendCmpct(peer, hash)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var data []byte
+		var found bool
+		var msg_type uint8
+		if inv_type == INV_TXN {
+			data, found = net.relayTxns.Get(hash)
+			msg_type = MSG_TXN
+		} else {
+			data, found = net._getBlockData(hash)
+			msg_type = MSG_BLOCK
+		}
+		if !found {
+			continue
+		}
+
+		err := peer.Write(Net_WriteHeader(msg_type, 0, data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,18 +17,25 @@ limitations under the License.
 package main
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"sync"
 )
 
+const PoolBlocks_MAX = 64
+const PoolTxns_MAX = 200000
+
 type PoolBlocks struct {
 	lock sync.Mutex
 
-	items [][]byte
+	items     [][]byte
+	max_items int
 }
 
-func NewPoolBlocks() *PoolBlocks {
+func NewPoolBlocks(max_items int) *PoolBlocks {
 	var self PoolBlocks
+	self.max_items = max_items
 	return &self
 }
 
@@ -39,12 +46,17 @@ func (pool *PoolBlocks) Num() int {
 	return len(pool.items)
 }
 
-func (pool *PoolBlocks) Add(item []byte) {
+func (pool *PoolBlocks) Add(item []byte) error {
 
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
 
+	if len(pool.items) >= pool.max_items {
+		return errors.New("PoolBlocks is full")
+	}
+
 	pool.items = append(pool.items, item)
+	return nil
 }
 
 func (pool *PoolBlocks) Get() ([]byte, error) {
@@ -61,17 +73,41 @@ func (pool *PoolBlocks) Get() ([]byte, error) {
 	return ret, nil
 }
 
+// Txns waiting for block, in order of arrival. Txns are also indexed by id(sha256 of msg), so block can be rebuilt from pool
 type PoolTxns struct {
 	lock sync.Mutex
 
-	items [][]byte
+	order     [][32]byte // can contain removed ids
+	items     map[[32]byte][]byte
+	max_items int
 }
 
-func NewPoolTxns() *PoolTxns {
+func NewPoolTxns(max_items int) *PoolTxns {
 	var self PoolTxns
+	self.items = make(map[[32]byte][]byte)
+	self.max_items = max_items
 	return &self
 }
 
+// returns msg part of item(pubKey + msg + sign), which is written into block
+func PoolTxns_Msg(item []byte) []byte {
+	return item[len(BLSPubKey{}.arr) : len(item)-len(BLSSign{}.arr)]
+}
+
+// returns src id and nonce of item
+func PoolTxns_Src(item []byte) (int64, int64, error) {
+	buff := NewTBuffer(PoolTxns_Msg(item))
+	src_id, err := buff.ReadNumber()
+	if err != nil {
+		return -1, -1, fmt.Errorf("PoolTxns_Src() failed: %w", err)
+	}
+	nonce, err := buff.ReadNumber()
+	if err != nil {
+		return -1, -1, fmt.Errorf("PoolTxns_Src() failed: %w", err)
+	}
+	return src_id, nonce, nil
+}
+
 func (pool *PoolTxns) Num() int {
 
 	pool.lock.Lock()
@@ -79,12 +115,23 @@ func (pool *PoolTxns) Num() int {
 	return len(pool.items)
 }
 
-func (pool *PoolTxns) Add(item []byte) {
+func (pool *PoolTxns) Add(id [32]byte, item []byte) error {
 
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
 
-	pool.items = append(pool.items, item)
+	if len(pool.items) >= pool.max_items {
+		return errors.New("PoolTxns is full")
+	}
+
+	_, found := pool.items[id]
+	if found {
+		return nil
+	}
+
+	pool.items[id] = item
+	pool.order = append(pool.order, id)
+	return nil
 }
 
 func (pool *PoolTxns) Get() ([]byte, error) {
@@ -92,11 +139,95 @@ func (pool *PoolTxns) Get() ([]byte, error) {
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
 
-	if len(pool.items) == 0 {
-		return nil, errors.New("PoolTxns is empty")
+	for len(pool.order) > 0 {
+		id := pool.order[0]
+		pool.order = pool.order[1:] //remove
+
+		item, found := pool.items[id]
+		if found {
+			delete(pool.items, id)
+			return item, nil
+		}
 	}
 
-	ret := pool.items[0]
-	pool.items = pool.items[1:] //remove
-	return ret, nil
+	return nil, errors.New("PoolTxns is empty")
+}
+
+// puts txns taken by Get() back to front of pool in same order(next txns of same account depend on them)
+func (pool *PoolTxns) Return(items [][]byte) {
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	var ids [][32]byte
+	for _, item := range items {
+		h, err := TxnRaw_Hash(PoolTxns_Msg(item))
+		if err != nil {
+			continue
+		}
+		id := [32]byte(h)
+		_, found := pool.items[id]
+		if found {
+			continue
+		}
+		pool.items[id] = item
+		ids = append(ids, id)
+	}
+	pool.order = append(ids, pool.order...)
+}
+
+// removes txns which were written into block by other node, ids are 32 bytes each
+func (pool *PoolTxns) Remove(ids []byte) {
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for i := 0; i+32 <= len(ids); i += 32 {
+		delete(pool.items, [32]byte(ids[i:i+32]))
+	}
+
+	// drops removed ids from order
+	if len(pool.order) > 2*len(pool.items)+1024 {
+		order := make([][32]byte, 0, len(pool.items))
+		for _, id := range pool.order {
+			_, found := pool.items[id]
+			if found {
+				order = append(order, id)
+			}
+		}
+		pool.order = order
+	}
+}
+
+// returns items for short ids(8 bytes each) of compact block, nil for missing txn or short id which matches more txns
+func (pool *PoolTxns) FindShorts(key *CmpctKey, shorts []byte) [][]byte {
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	wanted := make(map[uint64]int)
+	for i := 0; i+8 <= len(shorts); i += 8 {
+		wanted[binary.LittleEndian.Uint64(shorts[i:])] = 0
+	}
+
+	// salted short ids are computed for whole pool
+	found := make(map[uint64][]byte)
+	for id, item := range pool.items {
+		short := key.ShortId(id[:])
+		n, ok := wanted[short]
+		if !ok {
+			continue
+		}
+		wanted[short] = n + 1
+		found[short] = item
+	}
+
+	ret := make([][]byte, len(shorts)/8)
+	for i := range ret {
+		short := binary.LittleEndian.Uint64(shorts[i*8:])
+		if wanted[short] == 1 {
+			ret[i] = found[short]
+		}
+	}
+	return ret
 }
@@ -0,0 +1,655 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const Net_PROTOCOL_VERSION = 6
+const Net_NETWORK_DEFAULT = "tin"
+
+// Every frame starts with msg type(1 byte) and request id(8 bytes)
+const (
+	MSG_TXN       = 0
+	MSG_BLOCK     = 1
+	MSG_ACK       = 2
+	MSG_HELLO     = 3
+	MSG_INV       = 4
+	MSG_GETDATA   = 5
+	MSG_GETADDR   = 6
+	MSG_ADDR      = 7
+	MSG_GETHASHES = 8
+	MSG_HASHES    = 9
+
+	MSG_CMPCTBLOCK  = 10
+	MSG_GETBLOCKTXN = 11
+	MSG_BLOCKTXN    = 12
+
+	MSG_TXN_BATCH = 13
+
+	MSG_AUTH = 14
+)
+
+// bit mask of message types which every peer must understand
+const Net_REQUIRED_MSGS = (1 << MSG_TXN) | (1 << MSG_BLOCK) | (1 << MSG_ACK) | (1 << MSG_HELLO) | (1 << MSG_AUTH)
+
+// node-to-node relay. Clients(Connections) don't announce them
+const Net_RELAY_MSGS = (1 << MSG_INV) | (1 << MSG_GETDATA) | (1 << MSG_GETADDR) | (1 << MSG_ADDR) | (1 << MSG_GETHASHES) | (1 << MSG_HASHES) |
+	(1 << MSG_CMPCTBLOCK) | (1 << MSG_GETBLOCKTXN) | (1 << MSG_BLOCKTXN)
+
+// optional messages for clients
+const Net_CLIENT_MSGS = (1 << MSG_TXN_BATCH)
+
+const Net_SUPPORTED_MSGS = Net_REQUIRED_MSGS | Net_RELAY_MSGS | Net_CLIENT_MSGS
+
+const Net_HEADER_SIZE = 1 + 8
+
+const (
+	ACK_ACCEPTED = 0
+	ACK_REJECTED = 1
+)
+
+// Ack error codes
+const (
+	ACK_OK              = 0
+	ACK_BAD_SIGN        = 1
+	ACK_BAD_NONCE       = 2
+	ACK_NO_FUNDS        = 3
+	ACK_POOL_FULL       = 4
+	ACK_MALFORMED       = 5
+	ACK_UNKNOWN_ACCOUNT = 6
+	ACK_RATE_LIMITED    = 7
+	ACK_TOO_BIG         = 8
+	ACK_BUSY            = 9 // verification queue is full, try later
+	ACK_NOT_ALLOWED     = 10
+)
+
+func NetAck_CodeName(code uint8) string {
+	switch code {
+	case ACK_OK:
+		return "ok"
+	case ACK_BAD_SIGN:
+		return "bad signiture"
+	case ACK_BAD_NONCE:
+		return "bad nonce"
+	case ACK_NO_FUNDS:
+		return "insufficient funds"
+	case ACK_POOL_FULL:
+		return "pool full"
+	case ACK_MALFORMED:
+		return "malformed"
+	case ACK_UNKNOWN_ACCOUNT:
+		return "unknown account"
+	case ACK_RATE_LIMITED:
+		return "rate limited"
+	case ACK_TOO_BIG:
+		return "too big"
+	case ACK_BUSY:
+		return "busy"
+	case ACK_NOT_ALLOWED:
+		return "not allowed"
+	}
+	return fmt.Sprintf("unknown code(%d)", code)
+}
+
+func Net_WriteHeader(msg_type uint8, req_id uint64, payload []byte) []byte {
+	msg := make([]byte, Net_HEADER_SIZE, Net_HEADER_SIZE+len(payload))
+	msg[0] = msg_type
+	binary.LittleEndian.PutUint64(msg[1:], req_id)
+	return append(msg, payload...)
+}
+
+func Net_ReadHeader(message []byte) (uint8, uint64, []byte, error) {
+	if len(message) < Net_HEADER_SIZE {
+		return 0, 0, nil, errors.New("Net_ReadHeader() message is too small")
+	}
+	return message[0], binary.LittleEndian.Uint64(message[1:]), message[Net_HEADER_SIZE:], nil
+}
+
+type NetAck struct {
+	req_id uint64
+	status uint8
+	code   uint8
+	codes  []uint8 // per txn results of MSG_TXN_BATCH
+}
+
+func NewNetAck(req_id uint64, code uint8) *NetAck {
+	var ack NetAck
+	ack.req_id = req_id
+	ack.code = code
+	if code != ACK_OK {
+		ack.status = ACK_REJECTED
+	}
+	return &ack
+}
+
+// status and code are taken from first rejected txn
+func NewNetBatchAck(req_id uint64, codes []uint8) *NetAck {
+	code := uint8(ACK_OK)
+	for _, c := range codes {
+		if c != ACK_OK {
+			code = c
+			break
+		}
+	}
+	ack := NewNetAck(req_id, code)
+	ack.codes = codes
+	return ack
+}
+
+func (ack *NetAck) Serialize() []byte {
+	payload := append([]byte{ack.status, ack.code}, ack.codes...)
+	return Net_WriteHeader(MSG_ACK, ack.req_id, payload)
+}
+
+func (ack *NetAck) Deserialize(message []byte) error {
+	msg_type, req_id, payload, err := Net_ReadHeader(message)
+	if err != nil {
+		return fmt.Errorf("NetAck.Deserialize() failed: %w", err)
+	}
+	if msg_type != MSG_ACK || len(payload) < 2 {
+		return errors.New("NetAck.Deserialize() not an ack")
+	}
+	ack.req_id = req_id
+	ack.status = payload[0]
+	ack.code = payload[1]
+	ack.codes = nil
+	if len(payload) > 2 {
+		ack.codes = payload[2:]
+	}
+	return nil
+}
+
+// returns nil if accepted
+func (ack *NetAck) Error() error {
+	if ack.status == ACK_ACCEPTED {
+		return nil
+	}
+	return &NetAckError{code: ack.code}
+}
+
+type NetAckError struct {
+	code uint8
+}
+
+func (e *NetAckError) Error() string {
+	return "rejected: " + NetAck_CodeName(e.code)
+}
+
+var Net_ErrSelf = errors.New("connection to self")
+
+// First message in both directions
+type NetHello struct {
+	version    int64
+	network_id string
+	genesis    [32]byte
+	params     [32]byte  // NetParams.Hash()
+	height     int64     // -1 = no blocks
+	msgs       int64     // bit mask of supported message types
+	port       int64     // listening port, 0 = not listening(client)
+	nonce      int64     // random per node, detects connection to self
+	pubKey     BLSPubKey // node identity, zero = anonymous
+	challenge  [32]byte  // random per connection, other side signs it in MSG_AUTH
+}
+
+// pubKey can be nil(anonymous client)
+func NewNetHello(network_id string, genesis []byte, height int64, port int, nonce int64, pubKey *BLSPubKey) *NetHello {
+	var hello NetHello
+	hello.version = Net_PROTOCOL_VERSION
+	hello.network_id = network_id
+	copy(hello.genesis[:], genesis)
+	hello.params = NetParams_Active.Hash()
+	hello.height = height
+	hello.msgs = Net_SUPPORTED_MSGS
+	hello.port = int64(port)
+	hello.nonce = nonce
+	if pubKey != nil {
+		hello.pubKey = *pubKey
+	}
+	hello.challenge = NodeIdentity_Challenge()
+	return &hello
+}
+
+func (hello *NetHello) HasIdentity() bool {
+	return hello.pubKey != BLSPubKey{}
+}
+
+func (hello *NetHello) Serialize(req_id uint64) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(hello.version)
+	buff.WriteNumber(int64(len(hello.network_id)))
+	buff.WriteSBlob([]byte(hello.network_id))
+	buff.WriteSBlob(hello.genesis[:])
+	buff.WriteSBlob(hello.params[:])
+	buff.WriteNumber(hello.height)
+	buff.WriteNumber(hello.msgs)
+	buff.WriteNumber(hello.port)
+	buff.WriteNumber(hello.nonce)
+	buff.WriteSBlob(hello.pubKey.arr[:])
+	buff.WriteSBlob(hello.challenge[:])
+
+	return Net_WriteHeader(MSG_HELLO, req_id, buff.data[:buff.size])
+}
+
+func (hello *NetHello) Deserialize(message []byte) (uint64, error) {
+	msg_type, req_id, payload, err := Net_ReadHeader(message)
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	if msg_type != MSG_HELLO {
+		return 0, fmt.Errorf("NetHello.Deserialize() expected hello, got type(%d)", msg_type)
+	}
+
+	buff := NewTBuffer(payload)
+
+	hello.version, err = buff.ReadNumber()
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	if n < 0 || n > 256 {
+		return 0, errors.New("NetHello.Deserialize() network id is too long")
+	}
+	network_id := make([]byte, n)
+	err = buff.ReadSBlob(network_id, n)
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	hello.network_id = string(network_id)
+	err = buff.ReadSBlob(hello.genesis[:], int64(len(hello.genesis)))
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	err = buff.ReadSBlob(hello.params[:], int64(len(hello.params)))
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	hello.height, err = buff.ReadNumber()
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	hello.msgs, err = buff.ReadNumber()
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	hello.port, err = buff.ReadNumber()
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	hello.nonce, err = buff.ReadNumber()
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	err = buff.ReadSBlob(hello.pubKey.arr[:], int64(len(hello.pubKey.arr)))
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+	err = buff.ReadSBlob(hello.challenge[:], int64(len(hello.challenge)))
+	if err != nil {
+		return 0, fmt.Errorf("NetHello.Deserialize() failed: %w", err)
+	}
+
+	return req_id, nil
+}
+
+// checks if remote peer can talk with us
+func (hello *NetHello) Check(own *NetHello) error {
+	if hello.version != own.version {
+		return fmt.Errorf("incompatible protocol version(%d), expected(%d)", hello.version, own.version)
+	}
+	if hello.network_id != own.network_id {
+		return fmt.Errorf("different network(%s), expected(%s)", hello.network_id, own.network_id)
+	}
+	if hello.params != own.params {
+		return errors.New("different network parameters")
+	}
+	if hello.genesis != own.genesis {
+		return errors.New("different genesis")
+	}
+	if hello.nonce == own.nonce {
+		return Net_ErrSelf
+	}
+	if hello.msgs&Net_REQUIRED_MSGS != Net_REQUIRED_MSGS {
+		return fmt.Errorf("missing support for message types(%b)", Net_REQUIRED_MSGS&^hello.msgs)
+	}
+	return nil
+}
+
+func (hello *NetHello) IsSupported(msg_type uint8) bool {
+	return hello.msgs&(1<<msg_type) != 0
+}
+
+// Inventory item types
+const (
+	INV_TXN        = 0
+	INV_BLOCK      = 1
+	INV_CMPCTBLOCK = 2 // only in MSG_GETDATA, asks for MSG_CMPCTBLOCK instead of MSG_BLOCK
+)
+
+const NetInv_ITEM_SIZE = 1 + 32
+const NetInv_MAX = 20000 // items in one message
+
+// list of (type, hash) items, used by MSG_INV and MSG_GETDATA
+func NetInv_Serialize(msg_type uint8, items []byte) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(int64(len(items) / NetInv_ITEM_SIZE))
+	buff.WriteSBlob(items)
+	return Net_WriteHeader(msg_type, 0, buff.data[:buff.size])
+}
+
+func NetInv_Deserialize(payload []byte) ([]byte, error) {
+	buff := NewTBuffer(payload)
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return nil, fmt.Errorf("NetInv_Deserialize() failed: %w", err)
+	}
+	if n < 0 || n*NetInv_ITEM_SIZE != buff.size-buff.pos {
+		return nil, errors.New("NetInv_Deserialize() wrong number of items")
+	}
+	return buff.data[buff.pos:buff.size], nil
+}
+
+const NetAddr_MAX = 1000
+
+// list of "host:port" strings, used by MSG_ADDR
+func NetAddr_Serialize(addrs []string) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(int64(len(addrs)))
+	for _, addr := range addrs {
+		buff.WriteNumber(int64(len(addr)))
+		buff.WriteSBlob([]byte(addr))
+	}
+	return Net_WriteHeader(MSG_ADDR, 0, buff.data[:buff.size])
+}
+
+func NetAddr_Deserialize(payload []byte) ([]string, error) {
+	buff := NewTBuffer(payload)
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return nil, fmt.Errorf("NetAddr_Deserialize() failed: %w", err)
+	}
+	if n < 0 || n > NetAddr_MAX {
+		return nil, errors.New("NetAddr_Deserialize() too many addresses")
+	}
+
+	var addrs []string
+	for i := int64(0); i < n; i++ {
+		l, err := buff.ReadNumber()
+		if err != nil {
+			return nil, fmt.Errorf("NetAddr_Deserialize() failed: %w", err)
+		}
+		if l < 0 || l > 256 {
+			return nil, errors.New("NetAddr_Deserialize() address is too long")
+		}
+		addr := make([]byte, l)
+		err = buff.ReadSBlob(addr, l)
+		if err != nil {
+			return nil, fmt.Errorf("NetAddr_Deserialize() failed: %w", err)
+		}
+		addrs = append(addrs, string(addr))
+	}
+	return addrs, nil
+}
+
+const NetHashes_MAX = 2000
+
+// asks for block hashes[from, from+count)
+func NetGetHashes_Serialize(from int64, count int64) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(from)
+	buff.WriteNumber(count)
+	return Net_WriteHeader(MSG_GETHASHES, 0, buff.data[:buff.size])
+}
+
+func NetGetHashes_Deserialize(payload []byte) (int64, int64, error) {
+	buff := NewTBuffer(payload)
+	from, err := buff.ReadNumber()
+	if err != nil {
+		return 0, 0, fmt.Errorf("NetGetHashes_Deserialize() failed: %w", err)
+	}
+	count, err := buff.ReadNumber()
+	if err != nil {
+		return 0, 0, fmt.Errorf("NetGetHashes_Deserialize() failed: %w", err)
+	}
+	if from < 0 || count < 0 {
+		return 0, 0, errors.New("NetGetHashes_Deserialize() negative range")
+	}
+	return from, OsMin64(count, NetHashes_MAX), nil
+}
+
+// block hashes starting at height 'from'
+func NetHashes_Serialize(from int64, hashes []byte) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(from)
+	buff.WriteNumber(int64(len(hashes) / 32))
+	buff.WriteSBlob(hashes)
+	return Net_WriteHeader(MSG_HASHES, 0, buff.data[:buff.size])
+}
+
+func NetHashes_Deserialize(payload []byte) (int64, []byte, error) {
+	buff := NewTBuffer(payload)
+	from, err := buff.ReadNumber()
+	if err != nil {
+		return 0, nil, fmt.Errorf("NetHashes_Deserialize() failed: %w", err)
+	}
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return 0, nil, fmt.Errorf("NetHashes_Deserialize() failed: %w", err)
+	}
+	if from < 0 || n < 0 || n > NetHashes_MAX || n*32 != buff.size-buff.pos {
+		return 0, nil, errors.New("NetHashes_Deserialize() wrong number of hashes")
+	}
+	return from, buff.data[buff.pos:buff.size], nil
+}
+
+func NetCmpct_SignsSize() int {
+	return 96 * BlockVerMT_NUM_AGG_SIGNITURES
+}
+
+// block hash, nonce, aggregated signitures and short ids(8 bytes, CmpctKey.ShortId) of txns. Receiver rebuilds block from its txns pool
+func NetCmpctBlock_Serialize(hash []byte, nonce [8]byte, aggSigns []byte, shorts []byte) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteSBlob(hash)
+	buff.WriteSBlob(nonce[:])
+	buff.WriteSBlob(aggSigns)
+	buff.WriteNumber(int64(len(shorts) / 8))
+	buff.WriteSBlob(shorts)
+	return Net_WriteHeader(MSG_CMPCTBLOCK, 0, buff.data[:buff.size])
+}
+
+func NetCmpctBlock_Deserialize(payload []byte) ([32]byte, [8]byte, []byte, []byte, error) {
+	var hash [32]byte
+	var nonce [8]byte
+	buff := NewTBuffer(payload)
+	err := buff.ReadSBlob(hash[:], 32)
+	if err != nil {
+		return hash, nonce, nil, nil, fmt.Errorf("NetCmpctBlock_Deserialize() failed: %w", err)
+	}
+	err = buff.ReadSBlob(nonce[:], 8)
+	if err != nil {
+		return hash, nonce, nil, nil, fmt.Errorf("NetCmpctBlock_Deserialize() failed: %w", err)
+	}
+	aggSigns := make([]byte, NetCmpct_SignsSize())
+	err = buff.ReadSBlob(aggSigns, int64(len(aggSigns)))
+	if err != nil {
+		return hash, nonce, nil, nil, fmt.Errorf("NetCmpctBlock_Deserialize() failed: %w", err)
+	}
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return hash, nonce, nil, nil, fmt.Errorf("NetCmpctBlock_Deserialize() failed: %w", err)
+	}
+	if n < 0 || n*8 != buff.size-buff.pos {
+		return hash, nonce, nil, nil, errors.New("NetCmpctBlock_Deserialize() wrong number of short ids")
+	}
+	return hash, nonce, aggSigns, buff.data[buff.pos:buff.size], nil
+}
+
+// asks for txns of compact block, which are missing in pool
+func NetGetBlockTxn_Serialize(hash [32]byte, indexes []int) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteSBlob(hash[:])
+	buff.WriteNumber(int64(len(indexes)))
+	for _, i := range indexes {
+		buff.WriteNumber(int64(i))
+	}
+	return Net_WriteHeader(MSG_GETBLOCKTXN, 0, buff.data[:buff.size])
+}
+
+func NetGetBlockTxn_Deserialize(payload []byte) ([32]byte, []int, error) {
+	var hash [32]byte
+	buff := NewTBuffer(payload)
+	err := buff.ReadSBlob(hash[:], 32)
+	if err != nil {
+		return hash, nil, fmt.Errorf("NetGetBlockTxn_Deserialize() failed: %w", err)
+	}
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return hash, nil, fmt.Errorf("NetGetBlockTxn_Deserialize() failed: %w", err)
+	}
+	if n < 0 || n > buff.size-buff.pos {
+		return hash, nil, errors.New("NetGetBlockTxn_Deserialize() wrong number of indexes")
+	}
+	indexes := make([]int, n)
+	for i := range indexes {
+		v, err := buff.ReadNumber()
+		if err != nil {
+			return hash, nil, fmt.Errorf("NetGetBlockTxn_Deserialize() failed: %w", err)
+		}
+		indexes[i] = int(v)
+	}
+	return hash, indexes, nil
+}
+
+// txns(msg without pubKey and sign) of compact block
+func NetBlockTxn_Serialize(hash [32]byte, indexes []int, msgs [][]byte) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteSBlob(hash[:])
+	buff.WriteNumber(int64(len(indexes)))
+	for i, index := range indexes {
+		buff.WriteNumber(int64(index))
+		buff.WriteNumber(int64(len(msgs[i])))
+		buff.WriteSBlob(msgs[i])
+	}
+	return Net_WriteHeader(MSG_BLOCKTXN, 0, buff.data[:buff.size])
+}
+
+func NetBlockTxn_Deserialize(payload []byte) ([32]byte, []int, [][]byte, error) {
+	var hash [32]byte
+	buff := NewTBuffer(payload)
+	err := buff.ReadSBlob(hash[:], 32)
+	if err != nil {
+		return hash, nil, nil, fmt.Errorf("NetBlockTxn_Deserialize() failed: %w", err)
+	}
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return hash, nil, nil, fmt.Errorf("NetBlockTxn_Deserialize() failed: %w", err)
+	}
+	if n < 0 || n > buff.size-buff.pos {
+		return hash, nil, nil, errors.New("NetBlockTxn_Deserialize() wrong number of txns")
+	}
+	indexes := make([]int, n)
+	msgs := make([][]byte, n)
+	for i := range indexes {
+		v, err := buff.ReadNumber()
+		if err != nil {
+			return hash, nil, nil, fmt.Errorf("NetBlockTxn_Deserialize() failed: %w", err)
+		}
+		indexes[i] = int(v)
+
+		l, err := buff.ReadNumber()
+		if err != nil {
+			return hash, nil, nil, fmt.Errorf("NetBlockTxn_Deserialize() failed: %w", err)
+		}
+		if l < 0 || l > buff.size-buff.pos {
+			return hash, nil, nil, errors.New("NetBlockTxn_Deserialize() txn is too long")
+		}
+		msgs[i] = make([]byte, l)
+		err = buff.ReadSBlob(msgs[i], l)
+		if err != nil {
+			return hash, nil, nil, fmt.Errorf("NetBlockTxn_Deserialize() failed: %w", err)
+		}
+	}
+	return hash, indexes, msgs, nil
+}
+
+const NetTxnBatch_MAX = 10000
+
+// many txns(pubKey + msg + sign) in one frame, sender gets ack with result for every txn
+func NetTxnBatch_Serialize(txns [][]byte) []byte {
+	buff := NewTBuffer(nil)
+	buff.WriteNumber(int64(len(txns)))
+	for _, txn := range txns {
+		buff.WriteNumber(int64(len(txn)))
+		buff.WriteSBlob(txn)
+	}
+	return buff.data[:buff.size]
+}
+
+func NetTxnBatch_Deserialize(payload []byte) ([][]byte, error) {
+	buff := NewTBuffer(payload)
+	n, err := buff.ReadNumber()
+	if err != nil {
+		return nil, fmt.Errorf("NetTxnBatch_Deserialize() failed: %w", err)
+	}
+	if n < 0 || n > NetTxnBatch_MAX {
+		return nil, errors.New("NetTxnBatch_Deserialize() wrong number of txns")
+	}
+
+	txns := make([][]byte, n)
+	for i := range txns {
+		l, err := buff.ReadNumber()
+		if err != nil {
+			return nil, fmt.Errorf("NetTxnBatch_Deserialize() failed: %w", err)
+		}
+		if l <= 0 || l > buff.size-buff.pos {
+			return nil, errors.New("NetTxnBatch_Deserialize() wrong txn size")
+		}
+		txns[i] = buff.data[buff.pos : buff.pos+l]
+		buff.pos += l
+	}
+	if buff.pos != buff.size {
+		return nil, errors.New("NetTxnBatch_Deserialize() extra bytes after txns")
+	}
+	return txns, nil
+}
+
+// Proof of node identity, sign over both hellos(NodeIdentity_AuthMsg)
+func NetAuth_Serialize(req_id uint64, sign *BLSSign) []byte {
+	return Net_WriteHeader(MSG_AUTH, req_id, sign.arr[:])
+}
+
+func NetAuth_Deserialize(message []byte) (*BLSSign, error) {
+	msg_type, _, payload, err := Net_ReadHeader(message)
+	if err != nil {
+		return nil, fmt.Errorf("NetAuth_Deserialize() failed: %w", err)
+	}
+	if msg_type != MSG_AUTH {
+		return nil, fmt.Errorf("NetAuth_Deserialize() expected auth, got type(%d)", msg_type)
+	}
+	var sign BLSSign
+	if len(payload) != len(sign.arr) {
+		return nil, errors.New("NetAuth_Deserialize() wrong size")
+	}
+	sign.arr = [96]byte(payload)
+	return &sign, nil
+}
@@ -0,0 +1,741 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+
+	RPC_NOT_FOUND    = -32001
+	RPC_TXN_REJECTED = -32002
+	RPC_FORBIDDEN    = -32003
+)
+
+const Rpc_MAX_REQUEST = 4 * 1024 * 1024
+
+type RpcRequest struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type RpcResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RpcError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+func NewRpcError(code int, format string, a ...interface{}) *RpcError {
+	return &RpcError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+type RpcMethod func(params json.RawMessage) (interface{}, *RpcError)
+
+type Rpc struct {
+	node *Node
+
+	methods      map[string]RpcMethod
+	adminMethods map[string]RpcMethod // only from localhost
+}
+
+func NewRpc(node *Node) *Rpc {
+	var rpc Rpc
+	rpc.node = node
+
+	rpc.methods = map[string]RpcMethod{
+		"getBalance":         rpc.getBalance,
+		"getNonce":           rpc.getNonce,
+		"getAccountByPubKey": rpc.getAccountByPubKey,
+		"getAccount":         rpc.getAccount,
+		"submitTxn":          rpc.submitTxn,
+		"getBlock":           rpc.getBlock,
+		"getTxn":             rpc.getTxn,
+		"getChainInfo":       rpc.getChainInfo,
+		"getTxnStatus":       rpc.getTxnStatus,
+		"waitTxnStatus":      rpc.waitTxnStatus,
+		"validateAddress":    rpc.validateAddress,
+	}
+
+	rpc.adminMethods = map[string]RpcMethod{
+		"listBanned":  rpc.listBanned,
+		"setBan":      rpc.setBan,
+		"clearBanned": rpc.clearBanned,
+		"generate":    rpc.generate,
+	}
+
+	return &rpc
+}
+
+func (rpc *Rpc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC needs POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, Rpc_MAX_REQUEST))
+	if err != nil {
+		http.Error(w, "Reading body failed", http.StatusBadRequest)
+		return
+	}
+
+	var ret interface{}
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	ip := net.ParseIP(host)
+	isAdmin := ip != nil && ip.IsLoopback()
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		// batch
+		var reqs []json.RawMessage
+		err = json.Unmarshal(body, &reqs)
+		if err == nil && !rpc._takeRate(host, len(reqs)) {
+			http.Error(w, "Rate limited", http.StatusTooManyRequests)
+			return
+		}
+		if err != nil || len(reqs) == 0 {
+			ret = &RpcResponse{Jsonrpc: "2.0", Error: NewRpcError(RPC_PARSE_ERROR, "Parse error"), Id: json.RawMessage("null")}
+		} else {
+			var answers []*RpcResponse
+			for _, req := range reqs {
+				ans := rpc.Call(req, isAdmin)
+				if ans != nil {
+					answers = append(answers, ans)
+				}
+			}
+			if len(answers) == 0 {
+				w.WriteHeader(http.StatusNoContent) // only notifications
+				return
+			}
+			ret = answers
+		}
+	} else {
+		if !rpc._takeRate(host, 1) {
+			http.Error(w, "Rate limited", http.StatusTooManyRequests)
+			return
+		}
+		ans := rpc.Call(body, isAdmin)
+		if ans == nil {
+			w.WriteHeader(http.StatusNoContent) // notification
+			return
+		}
+		ret = ans
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(ret)
+	if err != nil {
+		fmt.Printf("Rpc.ServeHTTP() Encode() failed: %v\n", err)
+	}
+}
+
+// every request(also in batch) takes one token from IP rate, which is shared with anonymous peers
+func (rpc *Rpc) _takeRate(host string, n int) bool {
+	return rpc.node.net._ipBucket(host).TakeUpTo(n) == n
+}
+
+// returns nil for notification(request without id)
+func (rpc *Rpc) Call(data []byte, isAdmin bool) *RpcResponse {
+
+	var req RpcRequest
+	err := json.Unmarshal(data, &req)
+	if err != nil {
+		return &RpcResponse{Jsonrpc: "2.0", Error: NewRpcError(RPC_PARSE_ERROR, "Parse error: %v", err), Id: json.RawMessage("null")}
+	}
+
+	if req.Jsonrpc != "2.0" || len(req.Method) == 0 {
+		id := req.Id
+		if len(id) == 0 {
+			id = json.RawMessage("null")
+		}
+		return &RpcResponse{Jsonrpc: "2.0", Error: NewRpcError(RPC_INVALID_REQUEST, "Invalid request"), Id: id}
+	}
+
+	var result interface{}
+	var rpcErr *RpcError
+
+	method, ok := rpc.methods[req.Method]
+	adminMethod, okAdmin := rpc.adminMethods[req.Method]
+	if ok {
+		result, rpcErr = method(req.Params)
+	} else if okAdmin && isAdmin {
+		result, rpcErr = adminMethod(req.Params)
+	} else if okAdmin {
+		rpcErr = NewRpcError(RPC_FORBIDDEN, "Method(%s) is allowed only from localhost", req.Method)
+	} else {
+		rpcErr = NewRpcError(RPC_METHOD_NOT_FOUND, "Method(%s) not found", req.Method)
+	}
+
+	if len(req.Id) == 0 {
+		return nil
+	}
+
+	ans := &RpcResponse{Jsonrpc: "2.0", Id: req.Id}
+	if rpcErr != nil {
+		ans.Error = rpcErr
+	} else {
+		ans.Result = result
+	}
+	return ans
+}
+
+func _Rpc_parseParams(params json.RawMessage, dst interface{}) *RpcError {
+	if len(params) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(params, dst)
+	if err != nil {
+		return NewRpcError(RPC_INVALID_PARAMS, "Invalid params: %v", err)
+	}
+	return nil
+}
+
+func _Rpc_parseHex(str string, size int) ([]byte, *RpcError) {
+	data, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "Invalid hex: %v", err)
+	}
+	if size > 0 && len(data) != size {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "Invalid size(%d), expected(%d)", len(data), size)
+	}
+	return data, nil
+}
+
+type RpcAccountParams struct {
+	Id      *int64 `json:"id"`
+	PubKey  string `json:"pubKey"`
+	Address string `json:"address"`
+}
+
+type RpcAccount struct {
+	Id      int64  `json:"id"`
+	PubKey  string `json:"pubKey"`
+	Address string `json:"address"`
+	ShortId string `json:"shortId"` // address of id
+	Amount  int64  `json:"amount"`
+	Nonce   int64  `json:"nonce"`
+}
+
+func _Rpc_parseAddress(str string) (*Address, *RpcError) {
+	addr, err := Address_Parse(str)
+	if err != nil {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "%v", err)
+	}
+	return addr, nil
+}
+
+// account is searched by 'id', 'pubKey' or 'address'
+func (rpc *Rpc) _findAccount(params json.RawMessage) (*RpcAccount, *RpcError) {
+
+	var p RpcAccountParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	ledger := rpc.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	var pubKey *BLSPubKey
+	if len(p.Address) > 0 {
+		addr, rpcErr := _Rpc_parseAddress(p.Address)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		if addr.IsShort() {
+			p.Id = &addr.id
+		} else {
+			pubKey = addr.pubKey
+		}
+	} else if len(p.PubKey) > 0 {
+		data, rpcErr := _Rpc_parseHex(p.PubKey, len(BLSPubKey{}.arr))
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		pubKey = &BLSPubKey{arr: [48]byte(data)}
+	}
+
+	var id int
+	if p.Id != nil {
+		id = int(*p.Id)
+	} else if pubKey != nil {
+		var err error
+		id, err = ledger.accounts.Find(pubKey)
+		if err != nil {
+			return nil, NewRpcError(RPC_NOT_FOUND, "Account not found")
+		}
+	} else {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "Needs 'id', 'pubKey' or 'address'")
+	}
+
+	acc, err := ledger.accounts.Get(id)
+	if err != nil {
+		return nil, NewRpcError(RPC_NOT_FOUND, "Account not found: %v", err)
+	}
+
+	return &RpcAccount{Id: int64(id), PubKey: hex.EncodeToString(acc.pubKey.arr[:]), Address: NewAddressPubKey(&acc.pubKey).String(), ShortId: NewAddressId(int64(id)).String(), Amount: acc.amount, Nonce: acc.nonce}, nil
+}
+
+func (rpc *Rpc) getBalance(params json.RawMessage) (interface{}, *RpcError) {
+	acc, rpcErr := rpc._findAccount(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return acc.Amount, nil
+}
+
+func (rpc *Rpc) getNonce(params json.RawMessage) (interface{}, *RpcError) {
+	acc, rpcErr := rpc._findAccount(params)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return acc.Nonce, nil
+}
+
+func (rpc *Rpc) getAccountByPubKey(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcAccountParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if len(p.PubKey) == 0 {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "Needs 'pubKey'")
+	}
+	return rpc._findAccount(params)
+}
+
+// account by 'id', 'pubKey' or 'address'
+func (rpc *Rpc) getAccount(params json.RawMessage) (interface{}, *RpcError) {
+	return rpc._findAccount(params)
+}
+
+type RpcAddressParams struct {
+	Address string `json:"address"`
+}
+
+type RpcAddress struct {
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+	Type   string `json:"type,omitempty"` // pubKey or id
+	PubKey string `json:"pubKey,omitempty"`
+	Id     *int64 `json:"id,omitempty"`
+}
+
+// checks address without ledger, short id doesn't have to be registered
+func (rpc *Rpc) validateAddress(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcAddressParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	addr, err := Address_Parse(p.Address)
+	if err != nil {
+		return &RpcAddress{Error: err.Error()}, nil
+	}
+	if addr.IsShort() {
+		return &RpcAddress{Valid: true, Type: "id", Id: &addr.id}, nil
+	}
+	return &RpcAddress{Valid: true, Type: "pubKey", PubKey: hex.EncodeToString(addr.pubKey.arr[:])}, nil
+}
+
+type RpcTxnParams struct {
+	Txn string `json:"txn"` // hex of TxnRaw.ExportBuffer()
+	Id  string `json:"id"`
+}
+
+func (rpc *Rpc) submitTxn(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcTxnParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	txn, rpcErr := _Rpc_parseHex(p.Txn, -1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	id, code, err := rpc.node.net.AddTxn(txn)
+	if err != nil {
+		return nil, NewRpcError(RPC_TXN_REJECTED, "%s: %v", NetAck_CodeName(code), err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+type RpcBlockParams struct {
+	Height *int64 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+type RpcBlock struct {
+	Height  int64    `json:"height"`
+	Hash    string   `json:"hash"`
+	Size    int      `json:"size"`
+	NumTxns int      `json:"numTxns"`
+	Txns    []string `json:"txns"`
+	Data    string   `json:"data"`
+}
+
+func (rpc *Rpc) getBlock(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcBlockParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	ledger := rpc.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	var height int64
+	if p.Height != nil {
+		height = *p.Height
+	} else if len(p.Hash) > 0 {
+		hash, rpcErr := _Rpc_parseHex(p.Hash, 32)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		var err error
+		height, err = ledger.FindBlock(hash)
+		if err != nil {
+			return nil, NewRpcError(RPC_NOT_FOUND, "Block not found")
+		}
+	} else {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "Needs 'height' or 'hash'")
+	}
+
+	hash, data, err := ledger.GetBlock(height)
+	if err != nil {
+		return nil, NewRpcError(RPC_NOT_FOUND, "Block not found")
+	}
+
+	_, msgs, err := BlockRaw_ReadTxns(data)
+	if err != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+	}
+
+	ret := &RpcBlock{Height: height, Hash: hex.EncodeToString(hash), Size: len(data), NumTxns: len(msgs), Data: hex.EncodeToString(data)}
+	for _, msg := range msgs {
+		h, err := TxnRaw_Hash(msg)
+		if err != nil {
+			return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+		}
+		ret.Txns = append(ret.Txns, hex.EncodeToString(h))
+	}
+	return ret, nil
+}
+
+type RpcTxn struct {
+	Id        string `json:"id"`
+	Block     int64  `json:"block"`
+	SrcId     int64  `json:"srcId"`
+	Nonce     int64  `json:"nonce"`
+	Amount    int64  `json:"amount"`
+	Fee       int64  `json:"fee"`
+	DstId     *int64 `json:"dstId,omitempty"`
+	DstPubKey string `json:"dstPubKey,omitempty"`
+	Dst       string `json:"dst"` // address
+}
+
+func (rpc *Rpc) getTxn(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcTxnParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	id, rpcErr := _Rpc_parseHex(p.Id, 32)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	ledger := rpc.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	height, err := ledger.FindTxn(id)
+	if err != nil {
+		return nil, NewRpcError(RPC_NOT_FOUND, "Txn not found")
+	}
+	_, data, err := ledger.GetBlock(height)
+	if err != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+	}
+	txns, msgs, err := BlockRaw_ReadTxns(data)
+	if err != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+	}
+
+	for i, msg := range msgs {
+		h, err := TxnRaw_Hash(msg)
+		if err != nil {
+			return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+		}
+		if !bytes.Equal(h, id) {
+			continue
+		}
+
+		txn := &txns[i]
+		ret := &RpcTxn{Id: p.Id, Block: height, SrcId: txn.src_id, Nonce: txn.src_nonce, Amount: txn.amount, Fee: txn.fee}
+		if txn.dst_type == TxnRaw_SHORT {
+			dst_id := txn.dst_id
+			ret.DstId = &dst_id
+			ret.Dst = NewAddressId(dst_id).String()
+		} else {
+			ret.DstPubKey = hex.EncodeToString(txn.dst_pubKey.arr[:])
+			ret.Dst = NewAddressPubKey(&txn.dst_pubKey).String()
+		}
+		return ret, nil
+	}
+
+	return nil, NewRpcError(RPC_INTERNAL_ERROR, "Txn not found in block(%d)", height)
+}
+
+type RpcChainInfo struct {
+	Height      int64  `json:"height"` // -1 = no blocks
+	BestHash    string `json:"bestHash"`
+	NumAccounts int    `json:"numAccounts"`
+	PoolTxns    int    `json:"poolTxns"`
+	PoolBlocks  int    `json:"poolBlocks"`
+	Time        int64  `json:"time"` // unix, deterministic in regtest
+}
+
+func (rpc *Rpc) getChainInfo(params json.RawMessage) (interface{}, *RpcError) {
+
+	ledger := rpc.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	numBlocks, err := ledger.NumBlocks()
+	if err != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+	}
+
+	ret := &RpcChainInfo{Height: numBlocks - 1, NumAccounts: len(ledger.accounts.accounts)}
+	if numBlocks > 0 {
+		hash, _, err := ledger.GetBlock(numBlocks - 1)
+		if err != nil {
+			return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+		}
+		ret.BestHash = hex.EncodeToString(hash)
+	}
+	ret.PoolTxns = rpc.node.net.txnsPool.Num()
+	ret.PoolBlocks = rpc.node.net.blocksPool.Num()
+	ret.Time = rpc.node.Time()
+
+	return ret, nil
+}
+
+func (rpc *Rpc) getTxnStatus(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcTxnParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	id, rpcErr := _Rpc_parseHex(p.Id, 32)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	return rpc.node.net.TxnStatus(id), nil
+}
+
+type RpcWaitTxnStatusParams struct {
+	Id            string `json:"id"`
+	Status        string `json:"status"` // last known status
+	Confirmations int64  `json:"confirmations"`
+	Timeout       int64  `json:"timeout"` // ms
+}
+
+// long-poll: returns when status is different from passed one or after timeout
+func (rpc *Rpc) waitTxnStatus(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcWaitTxnStatusParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	id, rpcErr := _Rpc_parseHex(p.Id, 32)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 30000
+	}
+
+	return rpc.node.net.WaitTxnStatus(id, p.Status, p.Confirmations, time.Duration(p.Timeout)*time.Millisecond), nil
+}
+
+func (rpc *Rpc) listBanned(params json.RawMessage) (interface{}, *RpcError) {
+	return rpc.node.net.banList.List(), nil
+}
+
+type RpcBanParams struct {
+	Key     string `json:"key"` // IP address
+	Seconds int64  `json:"seconds"`
+	Reason  string `json:"reason"`
+}
+
+func (rpc *Rpc) setBan(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcBanParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if len(p.Key) == 0 {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "Needs 'key'")
+	}
+	if p.Seconds <= 0 {
+		p.Seconds = BanList_DURATION
+	}
+
+	err := rpc.node.net.banList.Ban(p.Key, p.Seconds, p.Reason)
+	if err != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+	}
+	return true, nil
+}
+
+// empty 'key' clears all bans
+func (rpc *Rpc) clearBanned(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcBanParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	err := rpc.node.net.banList.Clear(p.Key)
+	if err != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v", err)
+	}
+	return true, nil
+}
+
+type RpcGenerateParams struct {
+	Blocks  int    `json:"blocks"`
+	Address string `json:"address,omitempty"` // reward address
+}
+
+type RpcGenerated struct {
+	Height int64  `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// regtest: creates blocks from txns in pool now
+func (rpc *Rpc) generate(params json.RawMessage) (interface{}, *RpcError) {
+	var p RpcGenerateParams
+	rpcErr := _Rpc_parseParams(params, &p)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if p.Blocks < 1 || p.Blocks > 1000 {
+		return nil, NewRpcError(RPC_INVALID_PARAMS, "'blocks' must be 1-1000")
+	}
+
+	var reward *Address
+	if len(p.Address) > 0 {
+		reward, rpcErr = _Rpc_parseAddress(p.Address)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+
+	heights, hashes, err := rpc.node.Generate(p.Blocks, reward)
+	if err != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "%v(%d blocks were created)", err, len(heights))
+	}
+
+	ret := []RpcGenerated{}
+	for i := range heights {
+		ret = append(ret, RpcGenerated{Height: heights[i], Hash: hex.EncodeToString(hashes[i])})
+	}
+	return ret, nil
+}
+
+const Rpc_CLIENT_TIMEOUT = 60 * time.Second
+
+// calls method on remote node("host:port"), answer is decoded into result. tlsConf can be nil
+func Rpc_Call(addr string, tlsConf *NetTLS, method string, params interface{}, result interface{}) error {
+
+	paramsJs, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("Rpc_Call() Marshal() failed: %w", err)
+	}
+	body, err := json.Marshal(&RpcRequest{Jsonrpc: "2.0", Method: method, Params: paramsJs, Id: json.RawMessage("1")})
+	if err != nil {
+		return fmt.Errorf("Rpc_Call() Marshal() failed: %w", err)
+	}
+
+	client := http.Client{Timeout: Rpc_CLIENT_TIMEOUT}
+	url := "http://" + addr + "/rpc"
+	if tlsConf != nil {
+		conf, err := tlsConf.ClientConfig()
+		if err != nil {
+			return fmt.Errorf("Rpc_Call() failed: %w", err)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: conf}
+		url = "https://" + addr + "/rpc"
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Rpc_Call() Post() failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var ans struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RpcError       `json:"error"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, Rpc_MAX_REQUEST)).Decode(&ans)
+	if err != nil {
+		return fmt.Errorf("Rpc_Call() Decode() failed: %w", err)
+	}
+	if ans.Error != nil {
+		return fmt.Errorf("%s(%d): %s", method, ans.Error.Code, ans.Error.Message)
+	}
+	if result != nil {
+		err = json.Unmarshal(ans.Result, result)
+		if err != nil {
+			return fmt.Errorf("Rpc_Call() Unmarshal() failed: %w", err)
+		}
+	}
+	return nil
+}
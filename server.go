@@ -17,34 +17,107 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/herumi/bls-eth-go-binary/bls"
 )
 
 type Server struct {
+	node *Node
+	rpc  *Rpc
+
 	txnsPool   *PoolTxns
 	blocksPool *PoolBlocks
 
+	tlsConf *NetTLS // nil = without TLS
+
+	peers_lock sync.Mutex
+	peers      []*Peer
+	dialing    map[string]bool
+
+	addrBook *AddrBook
+	syncer   *Syncer
+	banList  *BanList
+
+	verifier   *Verifier
+	txnTracker *TxnTracker
+	events     *Events
+	signCache  *SignCache // shared with block verification
+
+	limits    NetLimits
+	ip_lock   sync.Mutex
+	ipBuckets map[string]*TokenBucket // ban key -> txns rate
+	numConns  atomic.Int32            // inbound websocket connections
+
+	blockSources *NetCache // block hash -> ban key of peer who sent it
+
+	seen        *NetCache    // txns and blocks which we already have
+	requested   *NetRequests // asked by MSG_GETDATA
+	relayTxns   *NetCache
+	relayBlocks *NetCache
+	relayCmpcts *NetCache // MSG_CMPCTBLOCK of relayed blocks
+
+	cmpct_lock sync.Mutex
+	cmpcts     map[[32]byte]*CmpctBlock
+
 	server         http.Server
 	isServerClosed bool
 }
 
+const Server_HELLO_TIMEOUT = 10 * time.Second
+
+const Server_SEEN_MAX = 500000
+const Server_RELAY_TXNS_MAX = 100000
+const Server_RELAY_BLOCKS_MAX = 16
+const Server_IP_BUCKETS_MAX = 10000
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 }
 
-func NewNet(ssl_on bool, port int) (*Server, error) {
+func NewNet(node *Node, tlsConf *NetTLS, port int, addrBook *AddrBook, banList *BanList, limits NetLimits) (*Server, error) {
 	var net Server
 
-	net.txnsPool = NewPoolTxns()
-	net.blocksPool = NewPoolBlocks()
-
-	go net.Loop(ssl_on, port)
+	net.node = node
+	net.rpc = NewRpc(node)
+
+	net.txnsPool = NewPoolTxns(PoolTxns_MAX)
+	net.signCache = NewSignCache(SignCache_MAX)
+	net.txnTracker = NewTxnTracker(TxnTracker_MAX)
+	net.events = NewEvents(&net)
+	net.txnTracker.onChange = net.events.OnTxnStatus
+	net.verifier = NewVerifier(0, Verifier_QUEUE_MAX, net.signCache)
+	net.blocksPool = NewPoolBlocks(PoolBlocks_MAX)
+
+	net.tlsConf = tlsConf
+	net.addrBook = addrBook
+	net.banList = banList
+	net.limits = limits
+	net.ipBuckets = make(map[string]*TokenBucket)
+	net.blockSources = NewNetCache(PoolBlocks_MAX + Syncer_WINDOW)
+	net.dialing = make(map[string]bool)
+	net.syncer = NewSyncer(&net)
+
+	net.seen = NewNetCache(Server_SEEN_MAX)
+	net.requested = NewNetRequests(Server_SEEN_MAX)
+	net.relayTxns = NewNetCache(Server_RELAY_TXNS_MAX)
+	net.relayBlocks = NewNetCache(Server_RELAY_BLOCKS_MAX)
+	net.relayCmpcts = NewNetCache(Server_RELAY_BLOCKS_MAX)
+	net.cmpcts = make(map[[32]byte]*CmpctBlock)
+
+	go net.Loop(port)
+	go net._relayLoop()
+	go net._outboundManager()
+	go net.syncer.Loop()
 
 	return &net, nil
 }
@@ -53,23 +126,500 @@ func (net *Server) Destroy() error {
 
 	net.isServerClosed = true
 	net.server.Close()
+
+	for _, peer := range net.GetPeers() {
+		peer.Close()
+	}
+	net.verifier.Destroy()
+
+	err := net.addrBook.Save()
+	if err != nil {
+		return fmt.Errorf("Destroy() failed: %w", err)
+	}
 	return nil
 }
 
-const MSG_TXN = 0
-const MSG_BLOCK = 1
+// parses txn(pubKey + msg + sign) and checks it against ledger, signiture is checked later by verifier
+func (net *Server) CheckTxn(message []byte) (*bls.PublicKey, *bls.Sign, uint8, error) {
+
+	var txn TxnRaw
+	_, pubKey, sign, err := txn.InitTxnFromBuffer(NewTBuffer(message), true, true)
+	if err != nil {
+		return nil, nil, ACK_MALFORMED, fmt.Errorf("CheckTxn() InitTxnFromBuffer() failed: %w", err)
+	}
+
+	ledger := net.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	acc, err := ledger.accounts.Get(int(txn.src_id))
+	if err != nil {
+		return nil, nil, ACK_UNKNOWN_ACCOUNT, fmt.Errorf("CheckTxn() get src_id failed: %w", err)
+	}
+	if !NewBLSPubKey(pubKey).Cmp(&acc.pubKey) {
+		return nil, nil, ACK_BAD_SIGN, errors.New("CheckTxn() PubKeys not match")
+	}
+	if txn.src_nonce < acc.nonce {
+		return nil, nil, ACK_BAD_NONCE, errors.New("CheckTxn() nonce was already used")
+	}
+	if txn.amount > acc.amount {
+		return nil, nil, ACK_NO_FUNDS, errors.New("CheckTxn() insufficient amount")
+	}
+
+	return pubKey, sign, ACK_OK, nil
+}
+
+// returns hash of signed message(TxnRaw_Hash)
+func Server_TxnId(message []byte) ([]byte, error) {
+	var txn TxnRaw
+	msg, _, _, err := txn.InitTxnFromBuffer(NewTBuffer(message), true, false)
+	if err != nil {
+		return nil, fmt.Errorf("Server_TxnId() InitTxnFromBuffer() failed: %w", err)
+	}
+	return TxnRaw_Hash(msg)
+}
+
+// checks txn, verifies signiture in worker pool, adds txn into pool and announces it to peers. done is called from verifier worker.
+// Already seen txn is accepted without checking
+func (net *Server) AddTxnAsync(message []byte, done func(id []byte, code uint8, err error)) {
+
+	id, err := Server_TxnId(message)
+	if err != nil {
+		done(nil, ACK_MALFORMED, fmt.Errorf("AddTxn() failed: %w", err))
+		return
+	}
+	if !net.seen.Add([32]byte(id), nil) {
+		done(id, ACK_OK, nil)
+		return
+	}
+
+	net.txnTracker.Set(id, TxnStatus_RECEIVED, "")
+
+	reject := func(code uint8, err error) {
+		net.seen.Remove([32]byte(id))
+		net.txnTracker.Set(id, TxnStatus_REJECTED, NetAck_CodeName(code))
+		done(nil, code, err)
+	}
+
+	pubKey, sign, code, err := net.CheckTxn(message)
+	if err != nil {
+		reject(code, err)
+		return
+	}
+
+	job := &VerifierJob{pubKey: pubKey, sign: sign, hash: id}
+	job.done = func(ok bool, err error) {
+		if err != nil {
+			reject(ACK_BUSY, fmt.Errorf("AddTxn() failed: %w", err))
+			return
+		}
+		if !ok {
+			reject(ACK_BAD_SIGN, errors.New("AddTxn() signiture is invalid"))
+			return
+		}
+
+		// status is set before Add(), txn can be written into block right after
+		net.txnTracker.Set(id, TxnStatus_POOL, "")
+		err = net.txnsPool.Add([32]byte(id), message) //including pubKey
+		if err != nil {
+			reject(ACK_POOL_FULL, fmt.Errorf("AddTxn() failed: %w", err))
+			return
+		}
+
+		net.events.OnPool(id, message)
+		net.Relay(INV_TXN, [32]byte(id), message)
+		done(id, ACK_OK, nil)
+	}
+
+	if !net.verifier.Submit(job) {
+		reject(ACK_BUSY, errors.New("AddTxn() verifier queue is full or closed"))
+	}
+}
+
+// same as AddTxnAsync(), but waits for result
+func (net *Server) AddTxn(message []byte) ([]byte, uint8, error) {
+
+	type Result struct {
+		id   []byte
+		code uint8
+		err  error
+	}
+
+	ch := make(chan Result, 1)
+	net.AddTxnAsync(message, func(id []byte, code uint8, err error) {
+		ch <- Result{id: id, code: code, err: err}
+	})
+	r := <-ch
+	return r.id, r.code, r.err
+}
+
+// adds block into pool, Node verifies it and relays it later
+func (net *Server) AddBlock(data []byte) ([]byte, uint8, error) {
+
+	if len(data) == 0 {
+		return nil, ACK_MALFORMED, errors.New("AddBlock() block is empty")
+	}
+
+	hash, err := TBuffer_sha256(data)
+	if err != nil {
+		return nil, ACK_MALFORMED, fmt.Errorf("AddBlock() failed: %w", err)
+	}
+	if !net.seen.Add([32]byte(hash), nil) {
+		return hash, ACK_OK, nil
+	}
+
+	err = net.blocksPool.Add(data)
+	if err != nil {
+		net.seen.Remove([32]byte(hash))
+		return nil, ACK_POOL_FULL, fmt.Errorf("AddBlock() failed: %w", err)
+	}
+	return hash, ACK_OK, nil
+}
+
+// reads peer's hello and answers with ours, then both sides prove their node keys. Incompatible peer gets close message with reason
+func (net *Server) Handshake(c *websocket.Conn) (*NetHello, error) {
+
+	c.SetReadDeadline(time.Now().Add(Server_HELLO_TIMEOUT))
+	defer c.SetReadDeadline(time.Time{})
+
+	mt, message, err := c.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("Handshake() ReadMessage() failed: %w", err)
+	}
+	if mt != websocket.BinaryMessage {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, "expected hello"))
+		return nil, errors.New("Handshake() message is not binary")
+	}
+
+	var hello NetHello
+	req_id, err := hello.Deserialize(message)
+	if err != nil {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, "expected hello"))
+		return nil, fmt.Errorf("Handshake() failed: %w", err)
+	}
+
+	own, err := net.node.Hello()
+	if err != nil {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""))
+		return nil, fmt.Errorf("Handshake() failed: %w", err)
+	}
+
+	err = hello.Check(own)
+	if err != nil {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+		return nil, fmt.Errorf("Handshake() peer refused: %w", err)
+	}
+
+	err = c.WriteMessage(websocket.BinaryMessage, own.Serialize(req_id))
+	if err != nil {
+		return nil, fmt.Errorf("Handshake() WriteMessage() failed: %w", err)
+	}
+
+	// client is checked before server signs anything for it
+	err = Net_ReadAuth(c, &hello, NodeIdentity_AuthMsg(NodeIdentity_ROLE_CLIENT, &hello, own))
+	if err != nil {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication failed"))
+		return nil, fmt.Errorf("Handshake() failed: %w", err)
+	}
+	err = net.CheckNode(&hello)
+	if err != nil {
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+		return nil, fmt.Errorf("Handshake() peer refused: %w", err)
+	}
+
+	err = Net_WriteAuth(c, net.node.identity, NodeIdentity_AuthMsg(NodeIdentity_ROLE_SERVER, &hello, own), req_id)
+	if err != nil {
+		return nil, fmt.Errorf("Handshake() failed: %w", err)
+	}
+
+	return &hello, nil
+}
+
+// rate shared by anonymous connections and rpc requests from same IP
+func (net *Server) _ipBucket(key string) *TokenBucket {
+	net.ip_lock.Lock()
+	defer net.ip_lock.Unlock()
+
+	bucket, found := net.ipBuckets[key]
+	if !found {
+		if len(net.ipBuckets) >= Server_IP_BUCKETS_MAX {
+			for k, b := range net.ipBuckets {
+				if b.IsIdle() {
+					delete(net.ipBuckets, k)
+				}
+			}
+		}
+		bucket = NewTokenBucket(net.limits.txns_per_sec_ip)
+		net.ipBuckets[key] = bucket
+	}
+	return bucket
+}
+
+// takes up to n txns from peer's rate, returns how many txns can be processed
+func (net *Server) _takeTxns(peer *Peer, n int) int {
+	n = peer.txnBucket.TakeUpTo(n)
+	if len(peer.ip) > 0 {
+		n = net._ipBucket(peer.ip).TakeUpTo(n)
+	}
+	return n
+}
+
+// true for txn which we asked this peer for by MSG_GETDATA(relay), it isn't rate limited
+func (net *Server) _isRequested(peer *Peer, txn []byte) bool {
+	id, err := Server_TxnId(txn)
+	return err == nil && net.requested.Take([32]byte(id), peer)
+}
+
+// reads messages from peer until link is closed
+func (net *Server) PeerLoop(peer *Peer) error {
+
+	if net.banList.IsBanned(peer.ban_key) {
+		return fmt.Errorf("PeerLoop() peer %s is banned", peer.ban_key)
+	}
+
+	net._addPeer(peer)
+	defer net._removePeer(peer)
+
+	if peer.IsRelay() {
+		if peer.inbound {
+			if len(peer.listen_addr) > 0 {
+				net.addrBook.Add(peer.listen_addr, AddrBook_SOURCE_INBOUND)
+				net.addrBook.MarkSeen(peer.listen_addr)
+			}
+		} else {
+			err := peer.Write(Net_WriteHeader(MSG_GETADDR, 0, nil))
+			if err != nil {
+				return fmt.Errorf("PeerLoop() failed: %w", err)
+			}
+		}
+	}
+
+	for {
+		mt, message, err := peer.conn.ReadMessage()
+
+		if mt == websocket.CloseMessage || websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err) {
+			return nil
+		}
+
+		if errors.Is(err, websocket.ErrReadLimit) {
+			net.Misbehave(peer, BAN_SCORE_GARBAGE, "frame over read limit")
+			return fmt.Errorf("PeerLoop() ReadMessage() failed: %w", err)
+		}
+		if err != nil {
+			net.Misbehave(peer, BAN_SCORE_GARBAGE, "unreadable frame")
+			return fmt.Errorf("PeerLoop() ReadMessage() failed: %w", err)
+		}
+
+		if mt != websocket.BinaryMessage {
+			net.Misbehave(peer, BAN_SCORE_GARBAGE, "frame is not binary")
+			return errors.New("PeerLoop() ReadMessage() is not binary")
+		}
+
+		msg_type, req_id, payload, err := Net_ReadHeader(message)
+		if err != nil {
+			net.Misbehave(peer, BAN_SCORE_GARBAGE, "frame without header")
+			return fmt.Errorf("PeerLoop() failed: %w", err)
+		}
+
+		if int64(len(payload)) > net.limits.MaxPayload(msg_type) {
+			net.Misbehave(peer, BAN_SCORE_GARBAGE, fmt.Sprintf("oversized frame(type %d, %d bytes)", msg_type, len(payload)))
+			err = peer.Write(NewNetAck(req_id, ACK_TOO_BIG).Serialize())
+			if err != nil {
+				return fmt.Errorf("PeerLoop() failed: %w", err)
+			}
+			continue
+		}
+
+		err = net._onMessage(peer, msg_type, req_id, payload)
+		if err != nil {
+			net.Misbehave(peer, BAN_SCORE_PROTOCOL, err.Error())
+			return fmt.Errorf("PeerLoop() failed: %w", err)
+		}
+	}
+}
+
+func (net *Server) _onTxnResult(peer *Peer, id []byte, code uint8, err error) {
+	if err != nil {
+		log.Printf("Error: AddTxn() failed: %v", err)
+	}
+	if code == ACK_BAD_SIGN || code == ACK_MALFORMED {
+		net.Misbehave(peer, BAN_SCORE_INVALID_TXN, NetAck_CodeName(code)+" txn")
+	}
+	if id != nil {
+		peer.known.Add([32]byte(id), nil)
+	}
+}
+
+func (net *Server) _onMessage(peer *Peer, msg_type uint8, req_id uint64, payload []byte) error {
+
+	switch msg_type {
+	case MSG_TXN:
+		if !net._isRequested(peer, payload) && net._takeTxns(peer, 1) == 0 {
+			return peer.Write(NewNetAck(req_id, ACK_RATE_LIMITED).Serialize())
+		}
+
+		// ack is sent after verification, read loop continues
+		net.AddTxnAsync(payload, func(id []byte, code uint8, err error) {
+			net._onTxnResult(peer, id, code, err)
+			peer.WriteAsync(NewNetAck(req_id, code).Serialize())
+		})
+		return nil
+
+	case MSG_TXN_BATCH:
+		txns, err := NetTxnBatch_Deserialize(payload)
+		if err != nil {
+			net.Misbehave(peer, BAN_SCORE_GARBAGE, "malformed txn batch")
+			return peer.Write(NewNetAck(req_id, ACK_MALFORMED).Serialize())
+		}
+		// batch can be bigger than bucket, txns over limit get 'rate limited' in ack
+		num_allowed := net._takeTxns(peer, len(txns))
+		codes := make([]uint8, len(txns))
+		for i := num_allowed; i < len(txns); i++ {
+			codes[i] = ACK_RATE_LIMITED
+		}
+		if num_allowed == 0 {
+			return peer.Write(NewNetBatchAck(req_id, codes).Serialize())
+		}
+
+		// one ack with all results is sent after last txn is verified
+		var num_left atomic.Int32
+		num_left.Store(int32(num_allowed))
+		for i, txn := range txns[:num_allowed] {
+			i := i
+			net.AddTxnAsync(txn, func(id []byte, code uint8, err error) {
+				net._onTxnResult(peer, id, code, err)
+				codes[i] = code
+				if num_left.Add(-1) == 0 {
+					peer.WriteAsync(NewNetBatchAck(req_id, codes).Serialize())
+				}
+			})
+		}
+		return nil
+
+	case MSG_BLOCK:
+		if !net._isNodeAllowed(peer.hello) {
+			return peer.Write(NewNetAck(req_id, ACK_NOT_ALLOWED).Serialize()) // permissioned network
+		}
+		if net.syncer.IsActive() {
+			hash, err := TBuffer_sha256(payload)
+			if err == nil && net.syncer.OnBlock([32]byte(hash), payload) {
+				net.seen.Add([32]byte(hash), nil)
+				net.blockSources.Add([32]byte(hash), []byte(peer.ban_key))
+				peer.known.Add([32]byte(hash), nil)
+				return peer.Write(NewNetAck(req_id, ACK_OK).Serialize())
+			}
+		}
+
+		hash, code, err := net.AddBlock(payload)
+		if err != nil {
+			log.Printf("Error: AddBlock() failed: %v", err)
+		}
+		if hash != nil {
+			peer.known.Add([32]byte(hash), nil)
+		}
+		if code == ACK_OK {
+			net.blockSources.Add([32]byte(hash), []byte(peer.ban_key))
+		}
+		if code == ACK_MALFORMED {
+			net.Misbehave(peer, BAN_SCORE_INVALID_BLOCK, "empty block")
+		}
+		return peer.Write(NewNetAck(req_id, code).Serialize()) // queued, verified later by Node
 
-func (net *Server) Loop(ssl_on bool, port int) error {
+	case MSG_ACK:
+		return nil // answers for relayed txns/blocks
+
+	case MSG_INV:
+		items, err := NetInv_Deserialize(payload)
+		if err != nil {
+			return err
+		}
+		return net._onInv(peer, items)
+
+	case MSG_GETDATA:
+		items, err := NetInv_Deserialize(payload)
+		if err != nil {
+			return err
+		}
+		return net._onGetData(peer, items)
+
+	case MSG_GETHASHES:
+		from, count, err := NetGetHashes_Deserialize(payload)
+		if err != nil {
+			return err
+		}
+		net.node.ledger.lock.RLock()
+		hashes, err := net.node.ledger.GetBlockHashes(from, count)
+		net.node.ledger.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+		return peer.Write(NetHashes_Serialize(from, hashes))
+
+	case MSG_HASHES:
+		from, hashes, err := NetHashes_Deserialize(payload)
+		if err != nil {
+			return err
+		}
+		net.syncer.OnHashes(peer, from, hashes)
+		return nil
+
+	case MSG_CMPCTBLOCK:
+		if !net._isNodeAllowed(peer.hello) {
+			return nil
+		}
+		return net._onCmpctBlock(peer, payload)
+
+	case MSG_GETBLOCKTXN:
+		return net._onGetBlockTxn(peer, payload)
+
+	case MSG_BLOCKTXN:
+		if !net._isNodeAllowed(peer.hello) {
+			return nil
+		}
+		return net._onBlockTxn(peer, payload)
+
+	case MSG_GETADDR:
+		return net._onGetAddr(peer)
+
+	case MSG_ADDR:
+		addrs, err := NetAddr_Deserialize(payload)
+		if err != nil {
+			return err
+		}
+		net._onAddr(peer, addrs)
+		return nil
+	}
+
+	net.Misbehave(peer, BAN_SCORE_PROTOCOL, fmt.Sprintf("unknown message type(%d)", msg_type))
+	return peer.Write(NewNetAck(req_id, ACK_MALFORMED).Serialize())
+}
+
+func (net *Server) Loop(port int) error {
 
 	mux := http.NewServeMux()
+	mux.Handle("/rpc", net.rpc)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 
 		fmt.Printf("Client accepted %s\n", r.URL.Path)
 
+		if r.URL.Path == "/data" || r.URL.Path == "/events" {
+			if net.banList.IsBanned(BanList_Key(r.RemoteAddr)) {
+				http.Error(w, "Banned", http.StatusForbidden)
+				return
+			}
+
+			if net.limits.max_connections > 0 && int(net.numConns.Load()) >= net.limits.max_connections {
+				http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+				return
+			}
+			net.numConns.Add(1)
+			defer net.numConns.Add(-1)
+		}
+
 		if r.URL.Path == "/" {
 			http.ServeFile(w, r, "tin.html")
 			return
-		} else if r.URL.Path == "/data" {
+		} else if r.URL.Path == "/events" {
 
 			c, err := upgrader.Upgrade(w, r, nil)
 			if err != nil {
@@ -77,62 +627,29 @@ func (net *Server) Loop(ssl_on bool, port int) error {
 				return
 			}
 			defer c.Close()
+			c.SetReadLimit(4096)
 
-			for {
-
-				mt, message, err := c.ReadMessage()
-
-				if mt == websocket.CloseMessage || websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err) {
-					return
-				}
-
-				if err != nil {
-					log.Printf("Error: ReadMessage() failed: %v\n", err)
-					return
-				}
+			net.events.Loop(c)
 
-				if mt != websocket.BinaryMessage {
-					log.Printf("Error: ReadMessage() is not binary: %v\n", err)
-					return
-				}
+		} else if r.URL.Path == "/data" {
 
-				if len(message) < 2 {
-					log.Printf("Error: ReadMessage() is too small: %v\n", err)
-					return
-				}
+			c, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				log.Printf("Error: RunHub() failed: %v\n", err)
+				return
+			}
+			defer c.Close()
+			c.SetReadLimit(Net_HEADER_SIZE + net.limits.max_other_frame)
 
-				//var ans []byte
-				if message[0] == MSG_TXN {
-					message = message[1:]
-
-					var txn TxnRaw
-					msg, pubKey, sign, err := txn.InitTxnFromBuffer(NewTBuffer(message), true, true)
-					if err != nil {
-						log.Printf("Error: InitTxnFromBuffer() failed: %v", err)
-						return
-					}
-					h, err := TBuffer_sha256(msg)
-					if err != nil {
-						log.Printf("Error: sha256() failed: %v", err)
-						return
-					}
-
-					if !sign.VerifyByte(pubKey, h) { //SLOW ...
-						log.Printf("Error: Signiture is invalid")
-						return
-					}
-
-					net.txnsPool.Add(message) //including pubKey
-
-				} else if message[0] == MSG_BLOCK {
-					net.blocksPool.Add(message[1:])
-				}
+			hello, err := net.Handshake(c)
+			if err != nil {
+				log.Printf("Error: Handshake() failed: %v\n", err)
+				return
+			}
 
-				/*err = c.WriteMessage(mt, ans)
-				if err != nil {
-					log.Println("Error: WriteMessage() failed: %w", err)
-					return
-				}*/
+			err = net.PeerLoop(NewPeer(c, r.RemoteAddr, true, hello, &net.limits))
+			if err != nil {
+				log.Printf("Error: PeerLoop() failed: %v\n", err)
 			}
 		}
 	})
@@ -140,8 +657,13 @@ func (net *Server) Loop(ssl_on bool, port int) error {
 	net.server = http.Server{Addr: ":" + strconv.Itoa(port), Handler: mux}
 
 	var err error
-	if ssl_on {
-		err = net.server.ListenAndServeTLS("ssl/cert.pem", "ssl/key.pem")
+	if net.tlsConf != nil {
+		net.server.TLSConfig, err = net.tlsConf.ServerConfig()
+		if err != nil {
+			log.Printf("Net.Loop() failed: %v\n", err)
+			return err
+		}
+		err = net.server.ListenAndServeTLS("", "") // certificate is in TLSConfig
 	} else {
 		err = net.server.ListenAndServe()
 	}
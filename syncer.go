@@ -0,0 +1,426 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const Syncer_TICK = 200 * time.Millisecond
+const Syncer_INFLIGHT_PER_PEER = 16
+const Syncer_REQUEST_TIMEOUT = 30 * time.Second
+const Syncer_WINDOW = 1024 // max number of blocks downloaded ahead of ledger
+
+type SyncerRequest struct {
+	peer *Peer
+	time time.Time
+}
+
+// Initial block download. Hashes are downloaded first from best peer, then blocks in parallel from all peers.
+// Every batch of hashes must start with hash which we already have and its last hash is confirmed by other peer(if there is one),
+// so one peer can't make us download blocks of chain which nobody else has.
+// Blocks are passed into blocksPool in order, Node verifies them with CheckAndWrite()
+type Syncer struct {
+	net *Server
+
+	active atomic.Bool
+
+	lock sync.Mutex
+
+	base       int64      // height of hashes[0]
+	hashes     [][32]byte // block hashes from peer
+	anchor     [32]byte   // hash of block base-1(in ledger)
+	has_anchor bool
+
+	hashes_peer *Peer // pending MSG_GETHASHES
+	hashes_time time.Time
+	hashes_skip *Peer // its last batch wasn't confirmed, next one is asked from other peer
+
+	unconfirmed      [][32]byte // batch which waits for confirm_peer
+	hashes_peer_last *Peer      // sent unconfirmed batch
+	confirm_peer     *Peer
+	confirm_time     time.Time
+
+	wanted   map[[32]byte]int64 // hash -> height
+	inflight map[int64]*SyncerRequest
+	blocks   map[int64][]byte // downloaded, waiting for blocksPool
+	next     int64            // next height for blocksPool
+}
+
+func NewSyncer(net *Server) *Syncer {
+	var self Syncer
+	self.net = net
+	self._reset(0)
+	return &self
+}
+
+func (syncer *Syncer) IsActive() bool {
+	return syncer.active.Load()
+}
+
+func (syncer *Syncer) _reset(height int64) {
+	syncer.base = height
+	syncer.next = height
+	syncer.hashes = nil
+	syncer.has_anchor = false
+	syncer.hashes_peer = nil
+	syncer.hashes_skip = nil
+	syncer.hashes_peer_last = nil
+	syncer.unconfirmed = nil
+	syncer.confirm_peer = nil
+	syncer.wanted = make(map[[32]byte]int64)
+	syncer.inflight = make(map[int64]*SyncerRequest)
+	syncer.blocks = make(map[int64][]byte)
+}
+
+func (syncer *Syncer) Loop() {
+	for !syncer.net.isServerClosed {
+		err := syncer.Tick()
+		if err != nil {
+			log.Printf("Syncer.Tick() failed: %v\n", err)
+		}
+		time.Sleep(Syncer_TICK)
+	}
+}
+
+func (syncer *Syncer) _height() (int64, error) {
+	ledger := syncer.net.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	numBlocks, err := ledger.NumBlocks()
+	if err != nil {
+		return -1, fmt.Errorf("_height() failed: %w", err)
+	}
+	return numBlocks - 1, nil
+}
+
+// hash of last block in ledger, batch of hashes must start with it
+func (syncer *Syncer) _setAnchor(height int64) error {
+	if height < 0 {
+		return nil
+	}
+	ledger := syncer.net.node.ledger
+	ledger.lock.RLock()
+	hashes, err := ledger.GetBlockHashes(height, 1)
+	ledger.lock.RUnlock()
+	if err != nil {
+		return fmt.Errorf("_setAnchor() failed: %w", err)
+	}
+	if len(hashes) != 32 {
+		return fmt.Errorf("_setAnchor() block(%d) not found", height)
+	}
+	syncer.anchor = [32]byte(hashes)
+	syncer.has_anchor = true
+	return nil
+}
+
+// last hash which next batch must start with
+func (syncer *Syncer) _lastHash() ([32]byte, bool) {
+	if len(syncer.hashes) > 0 {
+		return syncer.hashes[len(syncer.hashes)-1], true
+	}
+	return syncer.anchor, syncer.has_anchor
+}
+
+// height which at least two peers claim. Height of one peer is used only when nobody else is ahead of us, so one lying peer can't hold sync
+func Syncer_best(peers []*Peer, height int64) int64 {
+	best := int64(-1)
+	second := int64(-1)
+	for _, peer := range peers {
+		h := peer.height.Load()
+		if h > best {
+			second = best
+			best = h
+		} else if h > second {
+			second = h
+		}
+	}
+	if second > height {
+		return second
+	}
+	return best
+}
+
+func (syncer *Syncer) Tick() error {
+
+	height, err := syncer._height()
+	if err != nil {
+		return fmt.Errorf("Tick() failed: %w", err)
+	}
+
+	var peers []*Peer
+	for _, peer := range syncer.net.GetPeers() {
+		if peer.IsRelay() {
+			peers = append(peers, peer)
+		}
+	}
+
+	syncer.lock.Lock()
+	defer syncer.lock.Unlock()
+
+	now := time.Now()
+
+	// peer which didn't send hashes isn't trusted with its height anymore, sync stops when nobody else is ahead
+	if syncer.hashes_peer != nil && now.Sub(syncer.hashes_time) > Syncer_REQUEST_TIMEOUT {
+		peer := syncer.hashes_peer
+		syncer.hashes_peer = nil
+		log.Printf("Sync: %s didn't send hashes in time\n", peer.addr)
+		peer.height.Store(OsMin64(peer.height.Load(), syncer.base+int64(len(syncer.hashes))-1))
+		for _, p := range peers {
+			if p == peer {
+				syncer.net.Misbehave(peer, BAN_SCORE_SYNC_TIMEOUT, "no answer to GETHASHES")
+			}
+		}
+	}
+	best := Syncer_best(peers, height)
+
+	if !syncer.IsActive() {
+		if best <= height {
+			return nil
+		}
+		syncer._reset(height + 1)
+		err = syncer._setAnchor(height)
+		if err != nil {
+			return fmt.Errorf("Tick() failed: %w", err)
+		}
+		syncer.active.Store(true)
+		fmt.Printf("Sync started: height %d, best peer height %d\n", height, best)
+	}
+
+	if height >= best && syncer.next > best {
+		syncer._reset(height + 1)
+		syncer.active.Store(false)
+		fmt.Printf("Sync finished: height %d\n", height)
+		return nil
+	}
+
+	// block was passed into pool, but not written into ledger => verification failed, starts again
+	if syncer.next > height+1 && syncer.net.blocksPool.Num() == 0 && !syncer.net.node.IsVerifying() {
+		height, err = syncer._height() // could be written in meantime
+		if err != nil {
+			return fmt.Errorf("Tick() failed: %w", err)
+		}
+	}
+	if syncer.next > height+1 && syncer.net.blocksPool.Num() == 0 && !syncer.net.node.IsVerifying() {
+		log.Printf("Sync: block(%d) was rejected, downloading again\n", height+1)
+		syncer._reset(height + 1)
+		err = syncer._setAnchor(height)
+		if err != nil {
+			return fmt.Errorf("Tick() failed: %w", err)
+		}
+	}
+
+	// hashes
+	if syncer.unconfirmed != nil && now.Sub(syncer.confirm_time) > Syncer_REQUEST_TIMEOUT {
+		syncer.unconfirmed = nil
+		syncer.confirm_peer = nil
+	}
+	end := syncer.base + int64(len(syncer.hashes)) // first height without hash
+	if end <= best && syncer.unconfirmed == nil && syncer.hashes_peer == nil {
+		var bestPeer *Peer
+		for _, peer := range peers {
+			if peer.height.Load() >= end && peer != syncer.hashes_skip && (bestPeer == nil || peer.height.Load() > bestPeer.height.Load()) {
+				bestPeer = peer
+			}
+		}
+		if bestPeer == nil && syncer.hashes_skip != nil && syncer.hashes_skip.height.Load() >= end {
+			bestPeer = syncer.hashes_skip
+		}
+		if bestPeer != nil {
+			from := end
+			if _, ok := syncer._lastHash(); ok {
+				from = end - 1 // peer must return hash which we have as first one
+			}
+			syncer.hashes_peer = bestPeer
+			syncer.hashes_time = now
+			err := bestPeer.Write(NetGetHashes_Serialize(from, NetHashes_MAX))
+			if err != nil {
+				return fmt.Errorf("Tick() GetHashes failed: %w", err)
+			}
+		}
+	}
+
+	// timeouts
+	numInflight := make(map[*Peer]int)
+	for h, req := range syncer.inflight {
+		if now.Sub(req.time) > Syncer_REQUEST_TIMEOUT {
+			delete(syncer.inflight, h)
+			continue
+		}
+		numInflight[req.peer]++
+	}
+
+	// blocks
+	requests := make(map[*Peer][]byte)
+	for h := syncer.next; h < end && h < syncer.next+Syncer_WINDOW; h++ {
+		_, downloaded := syncer.blocks[h]
+		_, requested := syncer.inflight[h]
+		if downloaded || requested {
+			continue
+		}
+
+		var peer *Peer
+		for _, p := range peers {
+			if p.height.Load() >= h && numInflight[p] < Syncer_INFLIGHT_PER_PEER && (peer == nil || numInflight[p] < numInflight[peer]) {
+				peer = p
+			}
+		}
+		if peer == nil {
+			break
+		}
+
+		hash := syncer.hashes[h-syncer.base]
+		syncer.wanted[hash] = h
+		syncer.inflight[h] = &SyncerRequest{peer: peer, time: now}
+		numInflight[peer]++
+
+		requests[peer] = append(requests[peer], INV_BLOCK)
+		requests[peer] = append(requests[peer], hash[:]...)
+	}
+	for peer, items := range requests {
+		err := peer.Write(NetInv_Serialize(MSG_GETDATA, items))
+		if err != nil {
+			log.Printf("Sync: GetData to %s failed: %v\n", peer.addr, err)
+		}
+	}
+
+	// passes blocks in order
+	for syncer.net.blocksPool.Num() < PoolBlocks_MAX {
+		data, found := syncer.blocks[syncer.next]
+		if !found {
+			break
+		}
+		err := syncer.net.blocksPool.Add(data)
+		if err != nil {
+			break
+		}
+		delete(syncer.blocks, syncer.next)
+		syncer.next++
+	}
+
+	return nil
+}
+
+func (syncer *Syncer) OnHashes(peer *Peer, from int64, hashes []byte) {
+
+	syncer.lock.Lock()
+	defer syncer.lock.Unlock()
+
+	n := int64(len(hashes) / 32)
+
+	// answer to confirmation of last hash in batch
+	if peer == syncer.confirm_peer {
+		syncer.confirm_peer = nil
+		batch := syncer.unconfirmed
+		syncer.unconfirmed = nil
+
+		last := syncer.base + int64(len(syncer.hashes)+len(batch)) - 1
+		if !syncer.IsActive() || batch == nil || from != last || n != 1 {
+			return
+		}
+		if [32]byte(hashes) != batch[len(batch)-1] {
+			log.Printf("Sync: hash(%d) from %s doesn't match, downloading hashes again\n", last, peer.addr)
+			syncer.hashes_skip = syncer.hashes_peer_last
+			return
+		}
+		syncer.hashes = append(syncer.hashes, batch...)
+		return
+	}
+
+	// less hashes than maximum => peer's chain ends here
+	if n < NetHashes_MAX || from+n-1 > peer.height.Load() {
+		peer.height.Store(from + n - 1)
+	}
+
+	if peer == syncer.hashes_peer {
+		syncer.hashes_peer = nil
+	}
+	if !syncer.IsActive() || syncer.unconfirmed != nil {
+		return
+	}
+
+	end := syncer.base + int64(len(syncer.hashes))
+	last, ok := syncer._lastHash()
+	if ok {
+		if from != end-1 || n == 0 || [32]byte(hashes[:32]) != last {
+			log.Printf("Sync: hashes from %s don't connect to height %d\n", peer.addr, end-1)
+			syncer.hashes_skip = peer
+			return
+		}
+		hashes = hashes[32:]
+		from++
+		n--
+	} else if from != end {
+		return
+	}
+	if n == 0 {
+		return
+	}
+
+	var batch [][32]byte
+	for i := int64(0); i < n; i++ {
+		batch = append(batch, [32]byte(hashes[i*32:i*32+32]))
+	}
+
+	// other peer confirms last hash, without other peer batch is accepted
+	var confirm *Peer
+	for _, p := range syncer.net.GetPeers() {
+		if p != peer && p.IsRelay() && p.height.Load() >= from+n-1 {
+			confirm = p
+			break
+		}
+	}
+	if confirm == nil {
+		syncer.hashes = append(syncer.hashes, batch...)
+		return
+	}
+
+	err := confirm.Write(NetGetHashes_Serialize(from+n-1, 1))
+	if err != nil {
+		log.Printf("Sync: GetHashes to %s failed: %v\n", confirm.addr, err)
+		return
+	}
+	syncer.unconfirmed = batch
+	syncer.hashes_peer_last = peer
+	syncer.confirm_peer = confirm
+	syncer.confirm_time = time.Now()
+}
+
+// returns true if block was requested by syncer
+func (syncer *Syncer) OnBlock(hash [32]byte, data []byte) bool {
+
+	if !syncer.IsActive() {
+		return false
+	}
+
+	syncer.lock.Lock()
+	defer syncer.lock.Unlock()
+
+	h, found := syncer.wanted[hash]
+	if !found {
+		return false
+	}
+	delete(syncer.wanted, hash)
+	delete(syncer.inflight, h)
+	syncer.blocks[h] = data
+	return true
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLS for node links. Remote certificate is verified against CA bundle(or system roots) and/or pinned fingerprints
+type NetTLS struct {
+	cert_path string
+	key_path  string
+	ca_path   string     // empty = system roots
+	pins      [][32]byte // sha256 of remote certificate(DER)
+	mutual    bool       // server requires client certificate, clients send own certificate
+}
+
+func NetTLS_Default() NetTLS {
+	var self NetTLS
+	self.cert_path = "ssl/cert.pem"
+	self.key_path = "ssl/key.pem"
+	return self
+}
+
+// accepts hex with optional "sha256:" prefix and ':' separators
+func NetTLS_ParsePin(str string) ([32]byte, error) {
+	var pin [32]byte
+	str = strings.TrimPrefix(strings.ToLower(str), "sha256:")
+	str = strings.ReplaceAll(str, ":", "")
+	data, err := hex.DecodeString(str)
+	if err != nil || len(data) != 32 {
+		return pin, fmt.Errorf("NetTLS_ParsePin() invalid fingerprint(%s)", str)
+	}
+	copy(pin[:], data)
+	return pin, nil
+}
+
+func NetTLS_Fingerprint(der []byte) [32]byte {
+	return sha256.Sum256(der)
+}
+
+func (conf *NetTLS) _certPool() (*x509.CertPool, error) {
+	if len(conf.ca_path) == 0 {
+		return nil, nil
+	}
+	data, err := os.ReadFile(conf.ca_path)
+	if err != nil {
+		return nil, fmt.Errorf("_certPool() ReadFile() failed: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("_certPool() no certificates in %s", conf.ca_path)
+	}
+	return pool, nil
+}
+
+func (conf *NetTLS) _verifyPins(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate")
+	}
+	fp := NetTLS_Fingerprint(rawCerts[0])
+	for _, pin := range conf.pins {
+		if pin == fp {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate fingerprint %x is not pinned", fp)
+}
+
+func (conf *NetTLS) ServerConfig() (*tls.Config, error) {
+
+	cert, err := tls.LoadX509KeyPair(conf.cert_path, conf.key_path)
+	if err != nil {
+		return nil, fmt.Errorf("ServerConfig() LoadX509KeyPair() failed: %w", err)
+	}
+	ret := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if conf.mutual {
+		pool, err := conf._certPool()
+		if err != nil {
+			return nil, fmt.Errorf("ServerConfig() failed: %w", err)
+		}
+		if pool != nil {
+			ret.ClientAuth = tls.RequireAndVerifyClientCert
+			ret.ClientCAs = pool
+		} else if len(conf.pins) > 0 {
+			ret.ClientAuth = tls.RequireAnyClientCert // checked by pins only
+		} else {
+			return nil, errors.New("ServerConfig() mutual TLS needs CA or pins")
+		}
+		if len(conf.pins) > 0 {
+			ret.VerifyPeerCertificate = conf._verifyPins
+		}
+	}
+	return ret, nil
+}
+
+func (conf *NetTLS) ClientConfig() (*tls.Config, error) {
+
+	pool, err := conf._certPool()
+	if err != nil {
+		return nil, fmt.Errorf("ClientConfig() failed: %w", err)
+	}
+	ret := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	if len(conf.pins) > 0 {
+		if pool == nil {
+			ret.InsecureSkipVerify = true // self-signed certificate, verified by pins only
+		}
+		ret.VerifyPeerCertificate = conf._verifyPins
+	}
+
+	if conf.mutual {
+		cert, err := tls.LoadX509KeyPair(conf.cert_path, conf.key_path)
+		if err != nil {
+			return nil, fmt.Errorf("ClientConfig() LoadX509KeyPair() failed: %w", err)
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
+	return ret, nil
+}
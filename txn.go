@@ -27,11 +27,21 @@ const (
 	TxnRaw_SHORT = 1
 )
 
-const TxnRaw_ADJUST_SRC_ID = 1000000
-const TxnRaw_ADJUST_DST_ID = 1000000
-const TxnRaw_ADJUST_AMOUNT = 1000000
-
-const BlockVerMT_NUM_AGG_SIGNITURES = 8
+// consensus parameters, set by NetParams.Apply()
+var TxnRaw_ADJUST_SRC_ID int64 = 1000000
+var TxnRaw_ADJUST_DST_ID int64 = 1000000
+var TxnRaw_ADJUST_AMOUNT int64 = 1000000
+
+var BlockVerMT_NUM_AGG_SIGNITURES = 8
+
+// txn id and signed message. Chain id is part of it, so signed txn can't be replayed on other network
+func TxnRaw_Hash(msg []byte) ([]byte, error) {
+	var buff TBuffer
+	buff.WriteNumber(int64(len(NetParams_Active.NetworkId)))
+	buff.WriteSBlob([]byte(NetParams_Active.NetworkId))
+	buff.WriteSBlob(msg)
+	return TBuffer_sha256(buff.data[:buff.size])
+}
 
 type TxnRaw struct {
 	src_id    int64
@@ -160,7 +170,7 @@ func (txn *TxnRaw) ExportBuffer(pubKey *BLSPubKey, key *bls.SecretKey, buff *TBu
 	}
 
 	{
-		h, err := buff.sha256(len(pubKey.arr))
+		h, err := TxnRaw_Hash(buff.data[len(pubKey.arr):buff.size])
 		if err != nil {
 			return fmt.Errorf("ExportBuffer() failed: %w", err)
 		}
@@ -0,0 +1,196 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const TxnUnsigned_VERSION = 1
+
+// Txn for offline signing. It's created on online machine(src id and nonce are known from node), signed on offline machine
+type TxnUnsigned struct {
+	Version int    `json:"version"`
+	ChainId string `json:"chainId"` // signer refuses txn for other network
+	From    string `json:"from"`    // address of signer
+	SrcId   int64  `json:"srcId"`
+	Nonce   int64  `json:"nonce"`
+	Amount  int64  `json:"amount"`
+	Fee     int64  `json:"fee"`
+	Dst     string `json:"dst"` // address or short id
+}
+
+func NewTxnUnsigned(from *BLSPubKey, src_id int64, nonce int64, amount int64, fee int64, dst *Address) *TxnUnsigned {
+	var self TxnUnsigned
+	self.Version = TxnUnsigned_VERSION
+	self.ChainId = NetParams_Active.NetworkId
+	self.From = NewAddressPubKey(from).String()
+	self.SrcId = src_id
+	self.Nonce = nonce
+	self.Amount = amount
+	self.Fee = fee
+	self.Dst = dst.String()
+	return &self
+}
+
+func TxnUnsigned_Load(path string) (*TxnUnsigned, error) {
+	var self TxnUnsigned
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("TxnUnsigned_Load() ReadFile() failed: %w", err)
+	}
+	err = json.Unmarshal(data, &self)
+	if err != nil {
+		return nil, fmt.Errorf("TxnUnsigned_Load() Unmarshal() failed: %w", err)
+	}
+	return &self, nil
+}
+
+func (ut *TxnUnsigned) Save(path string) error {
+	if OsFileExists(path) {
+		return fmt.Errorf("TxnUnsigned.Save() %s already exists", path)
+	}
+	data, err := json.MarshalIndent(ut, "", "\t")
+	if err != nil {
+		return fmt.Errorf("TxnUnsigned.Save() Marshal() failed: %w", err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("TxnUnsigned.Save() WriteFile() failed: %w", err)
+	}
+	return nil
+}
+
+func (ut *TxnUnsigned) Check() error {
+	if ut.Version != TxnUnsigned_VERSION {
+		return fmt.Errorf("unsupported version(%d)", ut.Version)
+	}
+	if ut.ChainId != NetParams_Active.NetworkId {
+		return fmt.Errorf("txn is for chain '%s', not '%s'", ut.ChainId, NetParams_Active.NetworkId)
+	}
+	if ut.SrcId < 0 || ut.Nonce < 0 {
+		return fmt.Errorf("invalid src id(%d) or nonce(%d)", ut.SrcId, ut.Nonce)
+	}
+	if ut.Amount <= 0 || ut.Fee < 0 {
+		return fmt.Errorf("invalid amount(%d) or fee(%d)", ut.Amount, ut.Fee)
+	}
+	_, err := ut.FromPubKey()
+	if err != nil {
+		return err
+	}
+	_, err = Address_Parse(ut.Dst)
+	return err
+}
+
+func (ut *TxnUnsigned) FromPubKey() (*BLSPubKey, error) {
+	addr, err := Address_Parse(ut.From)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IsShort() {
+		return nil, errors.New("'from' must be pubKey address")
+	}
+	return addr.pubKey, nil
+}
+
+func (ut *TxnUnsigned) TxnRaw() (*TxnRaw, error) {
+	err := ut.Check()
+	if err != nil {
+		return nil, fmt.Errorf("TxnUnsigned.TxnRaw() %w", err)
+	}
+	dst, _ := Address_Parse(ut.Dst)
+
+	var txn TxnRaw
+	if dst.IsShort() {
+		txn.InitTxnRawShort(ut.SrcId, ut.Nonce, ut.Amount, ut.Fee, dst.id)
+	} else {
+		txn.InitTxnRawLong(ut.SrcId, ut.Nonce, ut.Amount, ut.Fee, dst.pubKey)
+	}
+	return &txn, nil
+}
+
+// returns bytes of TxnRaw.ExportBuffer(), same as submitTxn gets
+func (ut *TxnUnsigned) Sign(acc *ClientAccount) ([]byte, error) {
+	txn, err := ut.TxnRaw()
+	if err != nil {
+		return nil, err
+	}
+	from, _ := ut.FromPubKey()
+	if !from.Cmp(&acc.pubKey) {
+		return nil, fmt.Errorf("TxnUnsigned.Sign() txn must be signed by %s", ut.From)
+	}
+
+	var buff TBuffer
+	err = txn.ExportBuffer(&acc.pubKey, &acc.key, &buff)
+	if err != nil {
+		return nil, err
+	}
+	return buff.data[:buff.size], nil
+}
+
+func (ut *TxnUnsigned) String() string {
+	return fmt.Sprintf("chain: %s\nfrom: %s(id %d)\nnonce: %d\namount: %d\nfee: %d\nto: %s", ut.ChainId, ut.From, ut.SrcId, ut.Nonce, ut.Amount, ut.Fee, ut.Dst)
+}
+
+// parses signed txn, checks signiture and returns it as unsigned txn(for review) with txn id
+func TxnUnsigned_FromSigned(data []byte) (*TxnUnsigned, []byte, error) {
+	buff := NewTBuffer(data)
+	var txn TxnRaw
+	msg, pk, sig, err := txn.InitTxnFromBuffer(buff, true, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if buff.pos != int64(len(data)) {
+		return nil, nil, errors.New("TxnUnsigned_FromSigned() txn has extra bytes")
+	}
+
+	id, err := TxnRaw_Hash(msg) // chain id is part of signed message
+	if err != nil {
+		return nil, nil, err
+	}
+	if !sig.VerifyByte(pk, id) {
+		return nil, nil, fmt.Errorf("TxnUnsigned_FromSigned() invalid signiture or txn isn't for chain '%s'", NetParams_Active.NetworkId)
+	}
+
+	from := NewBLSPubKey(pk)
+	var dst *Address
+	if txn.dst_type == TxnRaw_SHORT {
+		dst = NewAddressId(txn.dst_id)
+	} else {
+		dst = NewAddressPubKey(&txn.dst_pubKey)
+	}
+	return NewTxnUnsigned(from, txn.src_id, txn.src_nonce, txn.amount, txn.fee, dst), id, nil
+}
+
+// signed txn file is hex
+func TxnUnsigned_ReadSigned(path string) ([]byte, error) {
+	str, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("TxnUnsigned_ReadSigned() ReadFile() failed: %w", err)
+	}
+	data, err := hex.DecodeString(strings.TrimSpace(string(str)))
+	if err != nil {
+		return nil, fmt.Errorf("TxnUnsigned_ReadSigned() %s is not hex: %w", path, err)
+	}
+	return data, nil
+}
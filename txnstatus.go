@@ -0,0 +1,240 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Lifecycle of txn
+const (
+	TxnStatus_UNKNOWN   = "unknown"
+	TxnStatus_RECEIVED  = "received"
+	TxnStatus_POOL      = "pool"
+	TxnStatus_BLOCK     = "block"     // included, less than TxnStatus_CONFIRMATIONS
+	TxnStatus_CONFIRMED = "confirmed" // included, at least TxnStatus_CONFIRMATIONS
+	TxnStatus_DROPPED   = "dropped"
+	TxnStatus_REJECTED  = "rejected"
+)
+
+const TxnStatus_CONFIRMATIONS = 6
+const TxnTracker_MAX = 500000
+const TxnTracker_WAIT_MAX = 60 * time.Second
+
+type TxnStatus struct {
+	Id            string `json:"id"` // TxnRaw_Hash() of signed message
+	Status        string `json:"status"`
+	Height        int64  `json:"height"` // -1 = not in block
+	Confirmations int64  `json:"confirmations"`
+	Reason        string `json:"reason,omitempty"`
+	Time          int64  `json:"time"` // unix time of last change, 0 = unknown
+}
+
+type TxnTrackerItem struct {
+	status string
+	height int64
+	reason string
+	time   int64
+}
+
+// Status of recently seen txns. Older txns are found in ledger(TxnIds table)
+type TxnTracker struct {
+	lock sync.Mutex
+
+	items map[[32]byte]*TxnTrackerItem
+	order [][32]byte
+	max   int
+
+	waits map[[32]byte][]chan struct{}
+
+	onChange func(id [32]byte) // called under lock
+	now      func() int64      // unix time, regtest has deterministic clock
+}
+
+func NewTxnTracker(max int) *TxnTracker {
+	var self TxnTracker
+	self.items = make(map[[32]byte]*TxnTrackerItem)
+	self.waits = make(map[[32]byte][]chan struct{})
+	self.max = max
+	self.now = func() int64 { return time.Now().Unix() }
+	return &self
+}
+
+func (tracker *TxnTracker) _set(id [32]byte, status string, height int64, reason string) {
+
+	it, found := tracker.items[id]
+	if !found {
+		for len(tracker.order) >= tracker.max {
+			delete(tracker.items, tracker.order[0])
+			tracker.order = tracker.order[1:]
+		}
+		it = &TxnTrackerItem{}
+		tracker.items[id] = it
+		tracker.order = append(tracker.order, id)
+	}
+	it.status = status
+	it.height = height
+	it.reason = reason
+	it.time = tracker.now()
+
+	for _, ch := range tracker.waits[id] {
+		close(ch)
+	}
+	delete(tracker.waits, id)
+
+	if tracker.onChange != nil {
+		tracker.onChange(id)
+	}
+}
+
+func (tracker *TxnTracker) Set(id []byte, status string, reason string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker._set([32]byte(id), status, -1, reason)
+}
+
+// txns(ids are 32 bytes each) were written into block. Wakes all waiters, because confirmations have changed
+func (tracker *TxnTracker) OnBlock(ids []byte, height int64) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	for i := 0; i+32 <= len(ids); i += 32 {
+		tracker._set([32]byte(ids[i:i+32]), TxnStatus_BLOCK, height, "")
+	}
+
+	for _, chs := range tracker.waits {
+		for _, ch := range chs {
+			close(ch)
+		}
+	}
+	tracker.waits = make(map[[32]byte][]chan struct{})
+}
+
+// txns(ids are 32 bytes each) were taken from pool, but not written into block
+func (tracker *TxnTracker) Drop(ids []byte, reason string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	for i := 0; i+32 <= len(ids); i += 32 {
+		tracker._set([32]byte(ids[i:i+32]), TxnStatus_DROPPED, -1, reason)
+	}
+}
+
+func (tracker *TxnTracker) Get(id []byte) (TxnTrackerItem, bool) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	it, found := tracker.items[[32]byte(id)]
+	if !found {
+		return TxnTrackerItem{}, false
+	}
+	return *it, true
+}
+
+// returned channel is closed after next change of txn
+func (tracker *TxnTracker) Waiter(id []byte) chan struct{} {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	ch := make(chan struct{})
+	tracker.waits[[32]byte(id)] = append(tracker.waits[[32]byte(id)], ch)
+	return ch
+}
+
+func (tracker *TxnTracker) RemoveWaiter(id []byte, ch chan struct{}) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	chs := tracker.waits[[32]byte(id)]
+	for i, c := range chs {
+		if c == ch {
+			chs = append(chs[:i], chs[i+1:]...)
+			break
+		}
+	}
+	if len(chs) == 0 {
+		delete(tracker.waits, [32]byte(id))
+	} else {
+		tracker.waits[[32]byte(id)] = chs
+	}
+}
+
+// returns status of txn from tracker or ledger
+func (net *Server) TxnStatus(id []byte) TxnStatus {
+
+	ret := TxnStatus{Id: hex.EncodeToString(id), Status: TxnStatus_UNKNOWN, Height: -1}
+
+	it, found := net.txnTracker.Get(id)
+	if found {
+		ret.Status = it.status
+		ret.Height = it.height
+		ret.Reason = it.reason
+		ret.Time = it.time
+	}
+
+	ledger := net.node.ledger
+	ledger.lock.RLock()
+	defer ledger.lock.RUnlock()
+
+	if ret.Height < 0 {
+		height, err := ledger.FindTxn(id)
+		if err == nil {
+			ret.Status = TxnStatus_BLOCK
+			ret.Height = height
+			ret.Reason = ""
+		}
+	}
+
+	if ret.Height >= 0 {
+		numBlocks, err := ledger.NumBlocks()
+		if err == nil {
+			ret.Confirmations = numBlocks - ret.Height
+		}
+		if ret.Confirmations >= TxnStatus_CONFIRMATIONS {
+			ret.Status = TxnStatus_CONFIRMED
+		}
+	}
+
+	return ret
+}
+
+// waits until status or confirmations are different from passed ones or until timeout
+func (net *Server) WaitTxnStatus(id []byte, status string, confirmations int64, timeout time.Duration) TxnStatus {
+
+	timeout = time.Duration(OsMin64(int64(timeout), int64(TxnTracker_WAIT_MAX)))
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		ch := net.txnTracker.Waiter(id) // registered before reading, so change can't be missed
+		st := net.TxnStatus(id)
+		if st.Status != status || st.Confirmations != confirmations {
+			net.txnTracker.RemoveWaiter(id, ch)
+			return st
+		}
+
+		select {
+		case <-ch:
+		case <-deadline.C:
+			net.txnTracker.RemoveWaiter(id, ch)
+			return st
+		}
+	}
+}
@@ -103,6 +103,18 @@ func OsMin(x, y int) int {
 	}
 	return x
 }
+func OsMin64(x, y int64) int64 {
+	if x > y {
+		return y
+	}
+	return x
+}
+func OsMax64(x, y int64) int64 {
+	if x < y {
+		return y
+	}
+	return x
+}
 func OsClamp(v, min, max int) int {
 	return OsMin(OsMax(v, min), max)
 }
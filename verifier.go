@@ -0,0 +1,232 @@
+/*
+Copyright 2023 Milan Suk
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/herumi/bls-eth-go-binary/bls"
+)
+
+const Verifier_BATCH = 256
+const Verifier_QUEUE_MAX = 65536
+const SignCache_MAX = 500000
+
+var Verifier_ErrClosed = errors.New("verifier is closed")
+
+type VerifierJob struct {
+	pubKey *bls.PublicKey
+	sign   *bls.Sign
+	hash   []byte // 32 bytes
+
+	done func(ok bool, err error) // called from worker, err is set when job wasn't verified(shutdown)
+}
+
+// Pool of workers which verify txn signitures. Jobs which are waiting in queue are verified together in batches
+type Verifier struct {
+	jobs  chan *VerifierJob
+	quit  chan struct{}
+	cache *SignCache // verified signitures are added here
+
+	lock    sync.RWMutex // Submit() vs Destroy()
+	closed  bool
+	workers sync.WaitGroup
+}
+
+func NewVerifier(num_workers int, queue_max int, cache *SignCache) *Verifier {
+	var self Verifier
+	self.cache = cache
+	self.jobs = make(chan *VerifierJob, queue_max)
+	self.quit = make(chan struct{})
+
+	if num_workers <= 0 {
+		num_workers = runtime.NumCPU()
+	}
+	self.workers.Add(num_workers)
+	for i := 0; i < num_workers; i++ {
+		go self._worker()
+	}
+	return &self
+}
+
+// stops workers, jobs which are still in queue fail with Verifier_ErrClosed
+func (ver *Verifier) Destroy() {
+	ver.lock.Lock()
+	ver.closed = true
+	close(ver.quit)
+	ver.lock.Unlock()
+
+	ver.workers.Wait()
+
+	for {
+		select {
+		case job := <-ver.jobs:
+			job.done(false, Verifier_ErrClosed)
+		default:
+			return
+		}
+	}
+}
+
+// returns false if queue is full or verifier is closed
+func (ver *Verifier) Submit(job *VerifierJob) bool {
+	ver.lock.RLock()
+	defer ver.lock.RUnlock()
+
+	if ver.closed {
+		return false
+	}
+	select {
+	case ver.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ver *Verifier) NumWaiting() int {
+	return len(ver.jobs)
+}
+
+func (ver *Verifier) _worker() {
+	defer ver.workers.Done()
+
+	batch := make([]*VerifierJob, 0, Verifier_BATCH)
+	oks := make([]bool, Verifier_BATCH)
+	for {
+		// waits for first job
+		select {
+		case job := <-ver.jobs:
+			batch = append(batch, job)
+		case <-ver.quit:
+			return
+		}
+
+		// takes other waiting jobs
+	fill:
+		for len(batch) < Verifier_BATCH {
+			select {
+			case job := <-ver.jobs:
+				batch = append(batch, job)
+			default:
+				break fill
+			}
+		}
+
+		Verifier_Check(batch, oks)
+		for i, job := range batch {
+			if oks[i] && ver.cache != nil {
+				ver.cache.Add(job.pubKey, job.hash, job.sign)
+			}
+			job.done(oks[i], nil)
+		}
+		batch = batch[:0]
+	}
+}
+
+// batch is verified at once(randomized, so invalid signitures can't cancel each other), if it fails, halves are verified to find bad ones
+func Verifier_Check(batch []*VerifierJob, out_oks []bool) {
+
+	if len(batch) == 1 {
+		out_oks[0] = batch[0].sign.VerifyByte(batch[0].pubKey, batch[0].hash)
+		return
+	}
+
+	signs := make([]bls.Sign, len(batch))
+	pubKeys := make([]bls.PublicKey, len(batch))
+	hashes := make([]byte, 0, len(batch)*32)
+	for i, job := range batch {
+		signs[i] = *job.sign
+		pubKeys[i] = *job.pubKey
+		hashes = append(hashes, job.hash...)
+	}
+
+	if bls.MultiVerify(signs, pubKeys, hashes) {
+		for i := range batch {
+			out_oks[i] = true
+		}
+		return
+	}
+
+	half := len(batch) / 2
+	Verifier_Check(batch[:half], out_oks[:half])
+	Verifier_Check(batch[half:], out_oks[half:])
+}
+
+// Signitures which were already verified. Key is sha256(pubKey + msg hash), so item doesn't depend on ledger state and stays valid after reorg
+type SignCache struct {
+	lock sync.RWMutex
+
+	items map[[32]byte]bls.Sign
+	order [][32]byte
+	max   int
+}
+
+func NewSignCache(max int) *SignCache {
+	var self SignCache
+	self.items = make(map[[32]byte]bls.Sign)
+	self.max = max
+	return &self
+}
+
+func SignCache_Key(pubKey *bls.PublicKey, hash []byte) [32]byte {
+	h := sha256.New()
+	h.Write(pubKey.Serialize())
+	h.Write(hash)
+	return [32]byte(h.Sum(nil))
+}
+
+func (cache *SignCache) Add(pubKey *bls.PublicKey, hash []byte, sign *bls.Sign) {
+
+	key := SignCache_Key(pubKey, hash)
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	_, found := cache.items[key]
+	if found {
+		return
+	}
+
+	for len(cache.order) >= cache.max {
+		delete(cache.items, cache.order[0])
+		cache.order = cache.order[1:]
+	}
+	cache.items[key] = *sign
+	cache.order = append(cache.order, key)
+}
+
+func (cache *SignCache) Get(pubKey *bls.PublicKey, hash []byte) (bls.Sign, bool) {
+
+	key := SignCache_Key(pubKey, hash)
+
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	sign, found := cache.items[key]
+	return sign, found
+}
+
+func (cache *SignCache) Num() int {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	return len(cache.items)
+}
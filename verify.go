@@ -30,28 +30,50 @@ func BlockVerMT_GetStartEnd(thread_i int, num_txns int) (int, int) {
 	return (thread_i * n), OsMin(num_txns, (thread_i*n)+n)
 }
 
-func _BlockVerMT_VerifyInner(st int, en int, aggSign *bls.Sign, block *BlockRaw, num_done *atomic.Uint32, out_ok *bool) {
+// if all txns were already verified, aggregated signiture is compared with sum of cached signitures(no pairing)
+func _BlockVerMT_IsCached(st int, en int, aggSign *bls.Sign, block *BlockRaw, cache *SignCache) bool {
+
+	if cache == nil {
+		return false
+	}
+
+	signs := make([]bls.Sign, en-st)
+	for i := st; i < en; i++ {
+		sign, found := cache.Get(&block.pubKeys[i], block.hashes[i*32:i*32+32])
+		if !found {
+			return false
+		}
+		signs[i-st] = sign
+	}
+
+	var sum bls.Sign
+	sum.Aggregate(signs)
+	return sum.IsEqual(aggSign)
+}
+
+func _BlockVerMT_VerifyInner(st int, en int, aggSign *bls.Sign, block *BlockRaw, cache *SignCache, num_done *atomic.Uint32, out_ok *bool) {
 
 	*out_ok = true
-	if en > st {
+	if en > st && !_BlockVerMT_IsCached(st, en, aggSign, block, cache) {
 		*out_ok = aggSign.AggregateVerifyNoCheck(block.pubKeys[st:en], block.hashes[st*32:en*32])
 	}
 	num_done.Add(1)
 }
 
-func BlockVerMT_Verify(aggSign []bls.Sign, block *BlockRaw) error {
+// cache can be nil
+func BlockVerMT_Verify(aggSign []bls.Sign, block *BlockRaw, cache *SignCache) error {
 
 	var num_done atomic.Uint32
-	var oks [BlockVerMT_NUM_AGG_SIGNITURES]bool
+	oks := make([]bool, BlockVerMT_NUM_AGG_SIGNITURES)
 
 	// runs
 	for i := 0; i < BlockVerMT_NUM_AGG_SIGNITURES; i++ {
 		st, en := BlockVerMT_GetStartEnd(i, block.NumTxns())
-		go _BlockVerMT_VerifyInner(st, en, &aggSign[i], block, &num_done, &oks[i])
+		go _BlockVerMT_VerifyInner(st, en, &aggSign[i], block, cache, &num_done, &oks[i])
 	}
 
 	//waits
-	for num_done.Load() < BlockVerMT_NUM_AGG_SIGNITURES {
+	for num_done.Load() < uint32(BlockVerMT_NUM_AGG_SIGNITURES) {
 		time.Sleep(1 * time.Millisecond)
 	}
 